@@ -1,8 +1,752 @@
 package main
 
+// dutyWaveforms holds the eight steps of each square wave duty cycle: 12.5%, 25%, 50% and 75%
+var dutyWaveforms = [4][8]uint8{
+	{0, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 0, 0, 1},
+	{1, 0, 0, 0, 0, 1, 1, 1},
+	{0, 1, 1, 1, 1, 1, 1, 0},
+}
+
+// SquareChannel is one of the two pulse channels. Only channel 1 has a frequency sweep.
+type SquareChannel struct {
+	// registers holds the last values written to NRx0-NRx4
+	registers [5]uint8
+
+	enabled    bool
+	dacEnabled bool
+
+	duty     uint8
+	dutyStep uint8
+	// frequency is the 11-bit value from NRx3 and NRx4, and timer counts down the cycles to the next duty step
+	frequency uint16
+	timer     int
+
+	LengthCounter
+	Envelope
+
+	hasSweep        bool
+	sweepPeriod     uint8
+	sweepDown       bool
+	sweepShift      uint8
+	sweepTimer      uint8
+	sweepEnabled    bool
+	shadowFrequency uint16
+	// sweepNegated records a calculation in the down direction since the last trigger. Switching the sweep back up
+	// afterwards disables the channel.
+	sweepNegated bool
+}
+
+// LengthCounter silences a channel once it has played for its length.
+type LengthCounter struct {
+	length        int
+	lengthEnabled bool
+}
+
+// TickLength counts down the length if it is enabled, and returns true if it has just run out.
+func (l *LengthCounter) TickLength() bool {
+	if l.lengthEnabled && l.length > 0 {
+		l.length--
+		return l.length == 0
+	}
+	return false
+}
+
+// SerializeState writes or reads the length counter.
+func (l *LengthCounter) SerializeState(s *StateSerializer) {
+	s.Int(&l.length)
+	s.Bool(&l.lengthEnabled)
+}
+
+// Envelope is the volume envelope shared by the square and noise channels.
+type Envelope struct {
+	initialVolume  uint8
+	envelopeUp     bool
+	envelopePeriod uint8
+
+	volume        uint8
+	envelopeTimer uint8
+}
+
+// WriteEnvelope sets the envelope from an NRx2 value. The DAC is on if any of the upper 5 bits are set.
+func (e *Envelope) WriteEnvelope(value uint8) (dacEnabled bool) {
+	e.initialVolume = value >> 4
+	e.envelopeUp = CheckBit(&value, 3)
+	e.envelopePeriod = value & 0x07
+	return value&0xF8 != 0
+}
+
+// TriggerEnvelope restarts the envelope at its initial volume.
+func (e *Envelope) TriggerEnvelope() {
+	e.volume = e.initialVolume
+	e.envelopeTimer = e.envelopePeriod
+}
+
+// ClockEnvelope moves the volume one step up or down every period clocks of the frame sequencer.
+// A period of 0 stops the envelope.
+func (e *Envelope) ClockEnvelope() {
+	if e.envelopePeriod == 0 {
+		return
+	}
+	if e.envelopeTimer > 0 {
+		e.envelopeTimer--
+	}
+	if e.envelopeTimer != 0 {
+		return
+	}
+	e.envelopeTimer = e.envelopePeriod
+	if e.envelopeUp && e.volume < 15 {
+		e.volume++
+	} else if !e.envelopeUp && e.volume > 0 {
+		e.volume--
+	}
+}
+
+// SerializeState writes or reads the envelope.
+func (e *Envelope) SerializeState(s *StateSerializer) {
+	s.Uint8(&e.initialVolume)
+	s.Bool(&e.envelopeUp)
+	s.Uint8(&e.envelopePeriod)
+	s.Uint8(&e.volume)
+	s.Uint8(&e.envelopeTimer)
+}
+
+// Read returns a register of the channel, 0 for NRx0 through 4 for NRx4.
+func (c *SquareChannel) Read(register int) uint8 {
+	return c.registers[register]
+}
+
+// Write sets a register of the channel, 0 for NRx0 through 4 for NRx4.
+func (c *SquareChannel) Write(register int, value uint8) {
+	c.registers[register] = value
+	switch register {
+	case 0:
+		c.sweepPeriod = (value >> 4) & 0x07
+		c.sweepDown = CheckBit(&value, 3)
+		c.sweepShift = value & 0x07
+		if !c.sweepDown && c.sweepNegated {
+			c.enabled = false
+		}
+	case 1:
+		c.duty = value >> 6
+		c.length = 64 - int(value&0x3F)
+	case 2:
+		c.dacEnabled = c.WriteEnvelope(value)
+		if !c.dacEnabled {
+			c.enabled = false
+		}
+	case 3:
+		c.frequency = c.frequency&0x0700 | uint16(value)
+	case 4:
+		c.frequency = c.frequency&0x00FF | uint16(value&0x07)<<8
+		c.lengthEnabled = CheckBit(&value, 6)
+		if CheckBit(&value, 7) {
+			c.Trigger()
+		}
+	}
+}
+
+// Period returns the number of cycles between duty steps.
+func (c *SquareChannel) Period() int {
+	return (2048 - int(c.frequency)) * 4
+}
+
+// Trigger restarts the channel.
+func (c *SquareChannel) Trigger() {
+	c.enabled = c.dacEnabled
+	if c.length == 0 {
+		c.length = 64
+	}
+	c.timer = c.Period()
+	c.TriggerEnvelope()
+
+	if c.hasSweep {
+		c.shadowFrequency = c.frequency
+		c.sweepTimer = c.sweepPeriod
+		if c.sweepTimer == 0 {
+			c.sweepTimer = 8
+		}
+		c.sweepEnabled = c.sweepPeriod != 0 || c.sweepShift != 0
+		c.sweepNegated = false
+		if c.sweepShift != 0 {
+			c.SweepFrequency()
+		}
+	}
+}
+
+// SweepFrequency calculates the next frequency of the sweep, disabling the channel if it overflows 11 bits.
+func (c *SquareChannel) SweepFrequency() uint16 {
+	delta := c.shadowFrequency >> c.sweepShift
+	frequency := c.shadowFrequency + delta
+	if c.sweepDown {
+		frequency = c.shadowFrequency - delta
+		c.sweepNegated = true
+	}
+	if frequency > 2047 {
+		c.enabled = false
+	}
+	return frequency
+}
+
+// ClockSweep is called at 128 Hz by the frame sequencer to step the frequency sweep.
+func (c *SquareChannel) ClockSweep() {
+	if c.sweepTimer > 0 {
+		c.sweepTimer--
+	}
+	if c.sweepTimer != 0 {
+		return
+	}
+	c.sweepTimer = c.sweepPeriod
+	if c.sweepTimer == 0 {
+		c.sweepTimer = 8
+	}
+
+	if !c.sweepEnabled || c.sweepPeriod == 0 {
+		return
+	}
+	frequency := c.SweepFrequency()
+	if frequency <= 2047 && c.sweepShift != 0 {
+		c.shadowFrequency = frequency
+		c.frequency = frequency
+		// The new frequency is checked for overflow again straight away, but not used
+		c.SweepFrequency()
+	}
+}
+
+// ClockLength is called at 256 Hz by the frame sequencer, and silences the channel when its length runs out.
+func (c *SquareChannel) ClockLength() {
+	if c.TickLength() {
+		c.enabled = false
+	}
+}
+
+// Step advances the channel's duty position by a number of cycles.
+func (c *SquareChannel) Step(cycles uint64) {
+	c.timer -= int(cycles)
+	for c.timer <= 0 {
+		c.timer += c.Period()
+		c.dutyStep = (c.dutyStep + 1) & 0x07
+	}
+}
+
+// Playing returns whether the channel is on.
+func (c *SquareChannel) Playing() bool {
+	return c.enabled
+}
+
+// DACEnabled returns whether the channel's DAC is on.
+func (c *SquareChannel) DACEnabled() bool {
+	return c.dacEnabled
+}
+
+// Sample returns the channel's current output, from 0 to 15.
+func (c *SquareChannel) Sample() uint8 {
+	if !c.enabled {
+		return 0
+	}
+	return dutyWaveforms[c.duty][c.dutyStep] * c.volume
+}
+
+// SerializeState writes or reads the channel's registers, timers, length, envelope and sweep.
+func (c *SquareChannel) SerializeState(s *StateSerializer) {
+	s.Raw(c.registers[:])
+	s.Bool(&c.enabled)
+	s.Bool(&c.dacEnabled)
+	s.Uint8(&c.duty)
+	s.Uint8(&c.dutyStep)
+	s.Uint16(&c.frequency)
+	s.Int(&c.timer)
+	c.LengthCounter.SerializeState(s)
+	c.Envelope.SerializeState(s)
+	s.Uint8(&c.sweepPeriod)
+	s.Bool(&c.sweepDown)
+	s.Uint8(&c.sweepShift)
+	s.Uint8(&c.sweepTimer)
+	s.Bool(&c.sweepEnabled)
+	s.Uint16(&c.shadowFrequency)
+	s.Bool(&c.sweepNegated)
+}
+
+// WaveChannel is channel 3, which plays 32 4-bit samples from wave RAM at 0xFF30-0xFF3F.
+type WaveChannel struct {
+	registers [5]uint8
+	ram       [16]uint8
+
+	enabled    bool
+	dacEnabled bool
+
+	// outputShift is how far samples are shifted right for the output level, with 4 muting the channel
+	outputShift uint8
+	frequency   uint16
+	timer       int
+	// position is the sample being played, and buffer holds its value
+	position uint8
+	buffer   uint8
+
+	LengthCounter
+}
+
+// waveOutputShifts maps the output level in bits 5-6 of NR32 to the shift applied to samples: mute, 100%, 50%
+// and 25%
+var waveOutputShifts = [4]uint8{4, 0, 1, 2}
+
+// Read returns a register of the channel, 0 for NR30 through 4 for NR34.
+func (c *WaveChannel) Read(register int) uint8 {
+	return c.registers[register]
+}
+
+// Write sets a register of the channel, 0 for NR30 through 4 for NR34.
+func (c *WaveChannel) Write(register int, value uint8) {
+	c.registers[register] = value
+	switch register {
+	case 0:
+		c.dacEnabled = CheckBit(&value, 7)
+		if !c.dacEnabled {
+			c.enabled = false
+		}
+	case 1:
+		c.length = 256 - int(value)
+	case 2:
+		c.outputShift = waveOutputShifts[(value>>5)&0x03]
+	case 3:
+		c.frequency = c.frequency&0x0700 | uint16(value)
+	case 4:
+		c.frequency = c.frequency&0x00FF | uint16(value&0x07)<<8
+		c.lengthEnabled = CheckBit(&value, 6)
+		if CheckBit(&value, 7) {
+			c.Trigger()
+		}
+	}
+}
+
+// Accessible returns whether the CPU can reach wave RAM.
+// While the channel plays, the DMG only lets the CPU at wave RAM in the cycle the channel reads a sample, and
+// then only at the byte being read.
+func (c *WaveChannel) Accessible() bool {
+	return !c.enabled || c.Period()-c.timer < 4
+}
+
+// ReadRAM returns a byte of wave RAM, or 0xFF if the channel is playing and has not just read wave RAM.
+func (c *WaveChannel) ReadRAM(index int) uint8 {
+	if c.enabled {
+		if !c.Accessible() {
+			return 0xFF
+		}
+		index = int(c.position / 2)
+	}
+	return c.ram[index]
+}
+
+// WriteRAM sets a byte of wave RAM, with the same restrictions as ReadRAM while the channel is playing.
+func (c *WaveChannel) WriteRAM(index int, value uint8) {
+	if c.enabled {
+		if !c.Accessible() {
+			return
+		}
+		index = int(c.position / 2)
+	}
+	c.ram[index] = value
+}
+
+// Period returns the number of cycles between samples.
+func (c *WaveChannel) Period() int {
+	return (2048 - int(c.frequency)) * 2
+}
+
+// Trigger restarts the channel from the start of wave RAM.
+// The first sample read is the second one, after a short delay. Until then the last sample keeps playing.
+func (c *WaveChannel) Trigger() {
+	c.enabled = c.dacEnabled
+	if c.length == 0 {
+		c.length = 256
+	}
+	c.position = 0
+	c.timer = c.Period() + 6
+}
+
+// ClockLength is called at 256 Hz by the frame sequencer, and silences the channel when its length runs out.
+func (c *WaveChannel) ClockLength() {
+	if c.TickLength() {
+		c.enabled = false
+	}
+}
+
+// Step advances the channel's position in wave RAM by a number of cycles.
+func (c *WaveChannel) Step(cycles uint64) {
+	if !c.enabled {
+		return
+	}
+	c.timer -= int(cycles)
+	for c.timer <= 0 {
+		c.timer += c.Period()
+		c.position = (c.position + 1) & 0x1F
+		// Samples are read from the high nibble of each byte first
+		c.buffer = c.ram[c.position/2] >> (4 * (1 - c.position%2)) & 0x0F
+	}
+}
+
+// Playing returns whether the channel is on.
+func (c *WaveChannel) Playing() bool {
+	return c.enabled
+}
+
+// DACEnabled returns whether the channel's DAC is on.
+func (c *WaveChannel) DACEnabled() bool {
+	return c.dacEnabled
+}
+
+// Sample returns the channel's current output, from 0 to 15.
+func (c *WaveChannel) Sample() uint8 {
+	if !c.enabled {
+		return 0
+	}
+	return c.buffer >> c.outputShift
+}
+
+// SerializeState writes or reads the channel's registers, wave RAM, timer, position and length.
+func (c *WaveChannel) SerializeState(s *StateSerializer) {
+	s.Raw(c.registers[:])
+	s.Raw(c.ram[:])
+	s.Bool(&c.enabled)
+	s.Bool(&c.dacEnabled)
+	s.Uint8(&c.outputShift)
+	s.Uint16(&c.frequency)
+	s.Int(&c.timer)
+	s.Uint8(&c.position)
+	s.Uint8(&c.buffer)
+	c.LengthCounter.SerializeState(s)
+}
+
+// NoiseChannel is channel 4, which plays pseudo-random noise from a linear feedback shift register.
+type NoiseChannel struct {
+	registers [5]uint8
+
+	enabled    bool
+	dacEnabled bool
+
+	// The LFSR is clocked every divisor << shift cycles. In 7-bit mode the feedback is also put in bit 6, which
+	// makes the sequence much shorter.
+	shift   uint8
+	width7  bool
+	divisor int
+	timer   int
+	lfsr    uint16
+
+	LengthCounter
+	Envelope
+}
+
+// Read returns a register of the channel, 1 for NR41 through 4 for NR44.
+func (c *NoiseChannel) Read(register int) uint8 {
+	return c.registers[register]
+}
+
+// Write sets a register of the channel, 1 for NR41 through 4 for NR44.
+func (c *NoiseChannel) Write(register int, value uint8) {
+	c.registers[register] = value
+	switch register {
+	case 1:
+		c.length = 64 - int(value&0x3F)
+	case 2:
+		c.dacEnabled = c.WriteEnvelope(value)
+		if !c.dacEnabled {
+			c.enabled = false
+		}
+	case 3:
+		c.shift = value >> 4
+		c.width7 = CheckBit(&value, 3)
+		c.divisor = int(value&0x07) * 16
+		if c.divisor == 0 {
+			c.divisor = 8
+		}
+	case 4:
+		c.lengthEnabled = CheckBit(&value, 6)
+		if CheckBit(&value, 7) {
+			c.Trigger()
+		}
+	}
+}
+
+// Period returns the number of cycles between clocks of the LFSR.
+func (c *NoiseChannel) Period() int {
+	return c.divisor << c.shift
+}
+
+// Trigger restarts the channel with all bits of the LFSR set.
+func (c *NoiseChannel) Trigger() {
+	c.enabled = c.dacEnabled
+	if c.length == 0 {
+		c.length = 64
+	}
+	c.timer = c.Period()
+	c.lfsr = 0x7FFF
+	c.TriggerEnvelope()
+}
+
+// ClockLFSR shifts the LFSR right by one, feeding back the XOR of its two low bits into bit 14.
+func (c *NoiseChannel) ClockLFSR() {
+	feedback := (c.lfsr ^ c.lfsr>>1) & 0x01
+	c.lfsr = c.lfsr>>1 | feedback<<14
+	if c.width7 {
+		c.lfsr = c.lfsr&^0x40 | feedback<<6
+	}
+}
+
+// ClockLength is called at 256 Hz by the frame sequencer, and silences the channel when its length runs out.
+func (c *NoiseChannel) ClockLength() {
+	if c.TickLength() {
+		c.enabled = false
+	}
+}
+
+// Step clocks the LFSR for a number of cycles. Shifts of 14 and 15 stop the LFSR.
+func (c *NoiseChannel) Step(cycles uint64) {
+	if !c.enabled || c.shift >= 14 {
+		return
+	}
+	c.timer -= int(cycles)
+	for c.timer <= 0 {
+		c.timer += c.Period()
+		c.ClockLFSR()
+	}
+}
+
+// Playing returns whether the channel is on.
+func (c *NoiseChannel) Playing() bool {
+	return c.enabled
+}
+
+// DACEnabled returns whether the channel's DAC is on.
+func (c *NoiseChannel) DACEnabled() bool {
+	return c.dacEnabled
+}
+
+// Sample returns the channel's current output, from 0 to 15. The output is high when bit 0 of the LFSR is clear.
+func (c *NoiseChannel) Sample() uint8 {
+	if !c.enabled || c.lfsr&0x01 != 0 {
+		return 0
+	}
+	return c.volume
+}
+
+// SerializeState writes or reads the channel's registers, LFSR, timer, length and envelope.
+func (c *NoiseChannel) SerializeState(s *StateSerializer) {
+	s.Raw(c.registers[:])
+	s.Bool(&c.enabled)
+	s.Bool(&c.dacEnabled)
+	s.Uint8(&c.shift)
+	s.Bool(&c.width7)
+	s.Int(&c.divisor)
+	s.Int(&c.timer)
+	s.Uint16(&c.lfsr)
+	c.LengthCounter.SerializeState(s)
+	c.Envelope.SerializeState(s)
+}
+
+// Channel is the interface shared by the four sound channels.
+type Channel interface {
+	// Read and Write access the channel's registers, 0 for NRx0 through 4 for NRx4
+	Read(register int) uint8
+	Write(register int, value uint8)
+	Step(cycles uint64)
+	ClockLength()
+	// Sample returns the channel's current output, from 0 to 15
+	Sample() uint8
+	Playing() bool
+	DACEnabled() bool
+	SerializeState(s *StateSerializer)
+}
+
+// APU is the audio processing unit.
+// Its frame sequencer is clocked at 512 Hz by the timer's divider, and steps the length counters, volume
+// envelopes and frequency sweep of the channels. The channels are mixed to stereo by NR50 and NR51, and the mixer
+// turns the result into samples at the output sample rate.
 type APU struct {
 	mmu *MMU
+
+	square1 SquareChannel
+	square2 SquareChannel
+	wave    WaveChannel
+	noise   NoiseChannel
+	// channels holds the four channels in order, for handling them all at once
+	channels [4]Channel
+
+	frameStep uint8
+	powered   bool
+	nr50      uint8
+	nr51      uint8
+
+	mixer Mixer
+}
+
+// Reset silences all channels, powers the APU on and registers the sound registers with the MMU.
+func (a *APU) Reset(mmu *MMU) {
+	a.mmu = mmu
+	a.square1 = SquareChannel{hasSweep: true}
+	a.square2 = SquareChannel{}
+	a.wave = WaveChannel{}
+	a.noise = NoiseChannel{divisor: 8}
+	a.channels = [4]Channel{&a.square1, &a.square2, &a.wave, &a.noise}
+	a.frameStep = 0
+	a.powered = true
+	a.nr50 = 0
+	a.nr51 = 0
+	a.mixer.Reset(AUDIOSAMPLERATE)
+
+	// Channels 2 and 4 have no NRx0, so 0xFF15 and 0xFF1F are unused
+	a.RegisterChannel(mmu, 0xFF10, 0, &a.square1)
+	a.RegisterChannel(mmu, 0xFF15, 1, &a.square2)
+	a.RegisterChannel(mmu, 0xFF1A, 0, &a.wave)
+	a.RegisterChannel(mmu, 0xFF1F, 1, &a.noise)
+
+	mmu.RegisterIO(0xFF24, func() uint8 { return a.nr50 }, func(value uint8) {
+		if a.powered {
+			a.nr50 = value
+		}
+	})
+	mmu.RegisterIO(0xFF25, func() uint8 { return a.nr51 }, func(value uint8) {
+		if a.powered {
+			a.nr51 = value
+		}
+	})
+	mmu.RegisterIO(0xFF26, a.ReadNR52, a.WriteNR52)
+
+	for i := 0; i < 16; i++ {
+		index := i
+		mmu.RegisterIO(0xFF30+uint16(i), func() uint8 { return a.wave.ReadRAM(index) }, func(value uint8) { a.wave.WriteRAM(index, value) })
+	}
+}
+
+// RegisterChannel registers the registers of a channel with the MMU, starting with register first at address.
+// While the APU is off only the length registers can be written, and only their length bits.
+func (a *APU) RegisterChannel(mmu *MMU, address uint16, first int, channel Channel) {
+	for i := first; i < 5; i++ {
+		register := i
+		mmu.RegisterIO(address+uint16(i), func() uint8 { return channel.Read(register) }, func(value uint8) {
+			switch {
+			case a.powered:
+				channel.Write(register, value)
+			case register == 1:
+				// The square channels keep their duty while the APU is off
+				if _, ok := channel.(*SquareChannel); ok {
+					value = value & 0x3F
+				}
+				channel.Write(register, value)
+			}
+		})
+	}
+}
+
+// ReadNR52 returns the power bit and whether each channel is playing.
+func (a *APU) ReadNR52() uint8 {
+	value := uint8(0)
+	if a.powered {
+		value |= 0x80
+	}
+	for i, channel := range a.channels {
+		if channel.Playing() {
+			value |= BitVal(uint8(i))
+		}
+	}
+	return value
+}
+
+// WriteNR52 switches the APU on or off with bit 7. The other bits are read-only.
+// Switching it off clears every sound register apart from wave RAM and the length counters. Switching it back on
+// restarts the frame sequencer.
+func (a *APU) WriteNR52(value uint8) {
+	on := CheckBit(&value, 7)
+	switch {
+	case a.powered && !on:
+		lengths := [4]int{a.square1.length, a.square2.length, a.wave.length, a.noise.length}
+		for _, channel := range a.channels {
+			for register := 0; register < 5; register++ {
+				channel.Write(register, 0)
+			}
+		}
+		a.square1.length, a.square2.length, a.wave.length, a.noise.length = lengths[0], lengths[1], lengths[2], lengths[3]
+		a.nr50 = 0
+		a.nr51 = 0
+	case !a.powered && on:
+		a.frameStep = 0
+		a.square1.dutyStep = 0
+		a.square2.dutyStep = 0
+		a.wave.buffer = 0
+	}
+	a.powered = on
+}
+
+// SerializeState writes or reads the power and mixing registers, the frame sequencer and each channel.
+// The mixer only holds the audio output, so it isn't part of the state.
+func (a *APU) SerializeState(s *StateSerializer) {
+	s.Bool(&a.powered)
+	s.Uint8(&a.nr50)
+	s.Uint8(&a.nr51)
+	s.Uint8(&a.frameStep)
+	for _, channel := range a.channels {
+		channel.SerializeState(s)
+	}
+}
+
+// ClockFrameSequencer advances the frame sequencer by one of its eight steps.
+// Lengths are clocked on even steps, the sweep on steps 2 and 6, and envelopes on step 7.
+func (a *APU) ClockFrameSequencer() {
+	if !a.powered {
+		return
+	}
+	if a.frameStep%2 == 0 {
+		for _, channel := range a.channels {
+			channel.ClockLength()
+		}
+	}
+	if a.frameStep == 2 || a.frameStep == 6 {
+		a.square1.ClockSweep()
+	}
+	if a.frameStep == 7 {
+		a.square1.ClockEnvelope()
+		a.square2.ClockEnvelope()
+		a.noise.ClockEnvelope()
+	}
+	a.frameStep = (a.frameStep + 1) & 0x07
+}
+
+// Step advances the channels by a number of cycles, mixing their output every machine cycle.
+func (a *APU) Step(cycles uint64) {
+	for i := uint64(0); i < cycles; i += 4 {
+		for _, channel := range a.channels {
+			channel.Step(4)
+		}
+		left, right := a.Mix()
+		a.mixer.Add(left, right, 4)
+	}
+}
+
+// Samples returns the current output of each channel, from 0 to 15.
+func (a *APU) Samples() [4]uint8 {
+	samples := [4]uint8{}
+	for i, channel := range a.channels {
+		samples[i] = channel.Sample()
+	}
+	return samples
 }
 
-func (g *APU) Reset(mmu *MMU) {
+// Mix returns the left and right outputs, from -1 to 1.
+// Each channel's DAC converts its output from 0-15 to 1 to -1, and channels with their DAC off are silent. NR51
+// pans each channel to the left or right or both, and NR50 sets the volume of each side from 1/8 to 8/8.
+func (a *APU) Mix() (left float64, right float64) {
+	for i, channel := range a.channels {
+		if !channel.DACEnabled() {
+			continue
+		}
+		analog := 1 - float64(channel.Sample())/7.5
+		if CheckBit(&a.nr51, uint8(i)+4) {
+			left += analog
+		}
+		if CheckBit(&a.nr51, uint8(i)) {
+			right += analog
+		}
+	}
+	leftVolume := float64((a.nr50>>4)&0x07+1) / 8
+	rightVolume := float64(a.nr50&0x07+1) / 8
+	return left / 4 * leftVolume, right / 4 * rightVolume
 }
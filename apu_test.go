@@ -0,0 +1,377 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSquareDuty(t *testing.T) {
+	tables := []struct {
+		duty uint8
+		wave string
+	}{
+		{0x00, "00000001"},
+		{0x40, "10000001"},
+		{0x80, "10000111"},
+		{0xC0, "01111110"},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF16, table.duty)
+		mmu.WriteByte(0xFF17, 0xF0)
+		// Frequency 2047 steps the duty every 4 cycles
+		mmu.WriteByte(0xFF18, 0xFF)
+		mmu.WriteByte(0xFF19, 0x87)
+
+		wave := ""
+		for i := 0; i < 8; i++ {
+			apu.Step(4)
+			if sample := apu.Samples()[1]; sample == 15 {
+				wave += "1"
+			} else if sample == 0 {
+				wave += "0"
+			} else {
+				wave += "?"
+			}
+		}
+		// Each step moves the duty position on before sampling, so the wave starts from its second step
+		wave = wave[7:] + wave[:7]
+		if wave != table.wave {
+			t.Errorf("Duty %X played %s, want %s", table.duty>>6, wave, table.wave)
+		}
+	}
+
+	apu, mmu := newTestAPU()
+	mmu.WriteByte(0xFF17, 0xF0)
+	mmu.WriteByte(0xFF18, 0x00)
+	mmu.WriteByte(0xFF19, 0x87)
+	// Frequency 0x700 steps the duty every (2048-1792)*4 = 1024 cycles
+	apu.Step(1020)
+	if apu.square2.dutyStep != 0 {
+		t.Errorf("Duty stepped after 1020 cycles")
+	}
+	apu.Step(4)
+	if apu.square2.dutyStep != 1 {
+		t.Errorf("Duty did not step after 1024 cycles")
+	}
+}
+
+func TestSquareLength(t *testing.T) {
+	tables := []struct {
+		nr21   uint8
+		nr24   uint8
+		clocks int
+		on     bool
+	}{
+		{0x3F, 0xC0, 0, true},
+		{0x3F, 0xC0, 1, false},
+		{0x00, 0xC0, 63, true},
+		{0x00, 0xC0, 64, false},
+		{0x00, 0x80, 64, true}, // Length disabled
+		{0x20, 0xC0, 31, true},
+		{0x20, 0xC0, 32, false},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF16, table.nr21)
+		mmu.WriteByte(0xFF17, 0xF0)
+		mmu.WriteByte(0xFF19, table.nr24)
+		// Lengths are clocked every other step
+		clockFrameSequencer(apu, 2*table.clocks)
+		if apu.square2.enabled != table.on {
+			t.Errorf("Length %d after %d clocks: on %v, want %v", 64-table.nr21&0x3F, table.clocks, apu.square2.enabled, table.on)
+		}
+	}
+}
+
+func TestSquareEnvelope(t *testing.T) {
+	tables := []struct {
+		nr22   uint8
+		clocks int
+		volume uint8
+	}{
+		{0xF0, 8, 15}, // Period 0 holds the volume
+		{0xF1, 1, 14},
+		{0xF1, 15, 0},
+		{0xF1, 20, 0},
+		{0xF3, 3, 14},
+		{0xF3, 6, 13},
+		{0x09, 1, 1},
+		{0x09, 20, 15},
+		{0x0A, 4, 2},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF17, table.nr22)
+		mmu.WriteByte(0xFF19, 0x80)
+		// Envelopes are clocked on the last of the eight steps
+		clockFrameSequencer(apu, 8*table.clocks)
+		if apu.square2.volume != table.volume {
+			t.Errorf("NR22 %X after %d clocks: volume %d, want %d", table.nr22, table.clocks, apu.square2.volume, table.volume)
+		}
+	}
+
+	apu, mmu := newTestAPU()
+	mmu.WriteByte(0xFF17, 0x07)
+	mmu.WriteByte(0xFF19, 0x80)
+	if apu.square2.enabled {
+		t.Error("Channel enabled with its DAC off")
+	}
+	mmu.WriteByte(0xFF17, 0xF0)
+	mmu.WriteByte(0xFF19, 0x80)
+	mmu.WriteByte(0xFF17, 0x00)
+	if apu.square2.enabled {
+		t.Error("Channel still enabled after turning its DAC off")
+	}
+}
+
+func TestSquareSweep(t *testing.T) {
+	tables := []struct {
+		name      string
+		nr10      uint8
+		frequency uint16
+		// Sweep clocks, which happen twice every 8 frame sequencer steps
+		clocks int
+		want   uint16
+		on     bool
+	}{
+		{"Up", 0x11, 0x100, 1, 0x180, true},
+		{"Up twice", 0x11, 0x100, 2, 0x240, true},
+		{"Down", 0x19, 0x100, 1, 0x080, true},
+		{"Period 2", 0x21, 0x100, 1, 0x100, true},
+		{"Period 2 twice", 0x21, 0x100, 2, 0x180, true},
+		{"Shift 0", 0x10, 0x100, 4, 0x100, true},
+		// The overflow check on trigger disables the channel straight away
+		{"Overflow on trigger", 0x11, 0x600, 0, 0x600, false},
+		// 0x500 sweeps to 0x780, whose next value 0xB40 fails the second overflow check
+		{"Overflow after update", 0x11, 0x500, 1, 0x780, false},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF10, table.nr10)
+		mmu.WriteByte(0xFF12, 0xF0)
+		mmu.WriteByte(0xFF13, uint8(table.frequency))
+		mmu.WriteByte(0xFF14, 0x80|uint8(table.frequency>>8))
+		// Sweeps are clocked on steps 2 and 6
+		clockFrameSequencer(apu, 4*table.clocks-1)
+		if table.clocks == 0 {
+			clockFrameSequencer(apu, 1)
+		}
+		if apu.square1.frequency != table.want || apu.square1.enabled != table.on {
+			t.Errorf("%s: frequency %X on %v, want %X on %v", table.name, apu.square1.frequency, apu.square1.enabled, table.want, table.on)
+		}
+	}
+
+	// Switching from down to up after a calculation in the down direction disables the channel
+	apu, mmu := newTestAPU()
+	mmu.WriteByte(0xFF10, 0x19)
+	mmu.WriteByte(0xFF12, 0xF0)
+	mmu.WriteByte(0xFF14, 0x81)
+	mmu.WriteByte(0xFF10, 0x11)
+	if apu.square1.enabled {
+		t.Error("Channel still enabled after leaving negate mode")
+	}
+}
+
+func TestFrameSequencerFromDIV(t *testing.T) {
+	timer, mmu := newTestTimer()
+	apu := &(APU{})
+	apu.Reset(mmu)
+	timer.OnFrameSequencer(apu.ClockFrameSequencer)
+
+	// The frame sequencer is clocked when bit 12 of the divider falls, every 8192 cycles
+	timer.Step(8192)
+	if apu.frameStep != 1 {
+		t.Errorf("Frame sequencer at step %d after 8192 cycles, want 1", apu.frameStep)
+	}
+	timer.Step(4096)
+	mmu.WriteByte(0xFF04, 0)
+	if apu.frameStep != 2 {
+		t.Errorf("Resetting DIV with bit 4 set left frame sequencer at step %d, want 2", apu.frameStep)
+	}
+}
+
+func TestWavePlayback(t *testing.T) {
+	apu, mmu := newTestAPU()
+	// Sample n of wave RAM is n mod 16
+	for i := uint16(0); i < 16; i++ {
+		mmu.WriteByte(0xFF30+i, uint8(2*i)<<4|uint8(2*i+1)&0x0F)
+	}
+	mmu.WriteByte(0xFF1A, 0x80)
+	mmu.WriteByte(0xFF1C, 0x20)
+	// Frequency 0x7FE reads a sample every 4 cycles, after a 6 cycle delay on trigger
+	mmu.WriteByte(0xFF1D, 0xFE)
+	mmu.WriteByte(0xFF1E, 0x87)
+
+	positions := []uint8{0, 0, 0, 1, 2, 3, 4}
+	for i, position := range positions {
+		if apu.wave.position != position {
+			t.Errorf("After %d cycles wave position was %d, want %d", 4*i, apu.wave.position, position)
+		}
+		if position != 0 && apu.Samples()[2] != position {
+			t.Errorf("After %d cycles wave sample was %d, want %d", 4*i, apu.Samples()[2], position)
+		}
+		apu.Step(4)
+	}
+
+	// Position 29 is in the second half of the loop
+	apu.Step(4 * 24)
+	if apu.wave.position != 29 || apu.Samples()[2] != 13 {
+		t.Errorf("Wave at position %d playing %d, want 29 playing 13", apu.wave.position, apu.Samples()[2])
+	}
+	apu.Step(4 * 3)
+	if apu.wave.position != 0 || apu.Samples()[2] != 0 {
+		t.Errorf("Wave did not loop back to position 0, at %d", apu.wave.position)
+	}
+
+	tables := []struct {
+		nr32   uint8
+		sample uint8
+	}{
+		{0x00, 0},
+		{0x20, 13},
+		{0x40, 6},
+		{0x60, 3},
+	}
+	for _, table := range tables {
+		mmu.WriteByte(0xFF1C, table.nr32)
+		apu.wave.buffer = 13
+		if sample := apu.Samples()[2]; sample != table.sample {
+			t.Errorf("Output level %X played %d, want %d", table.nr32>>5, sample, table.sample)
+		}
+	}
+
+	mmu.WriteByte(0xFF1A, 0x00)
+	if apu.wave.enabled {
+		t.Error("Wave channel still enabled after turning its DAC off")
+	}
+}
+
+func TestWaveRAMAccess(t *testing.T) {
+	apu, mmu := newTestAPU()
+	for i := uint16(0); i < 16; i++ {
+		mmu.WriteByte(0xFF30+i, uint8(i))
+	}
+	if mmu.ReadByte(0xFF35) != 5 {
+		t.Errorf("Wave RAM read as %X while stopped, want 5", mmu.ReadByte(0xFF35))
+	}
+
+	// Frequency 0x7C0 reads a sample every 128 cycles
+	mmu.WriteByte(0xFF1A, 0x80)
+	mmu.WriteByte(0xFF1D, 0xC0)
+	mmu.WriteByte(0xFF1E, 0x87)
+
+	apu.Step(132)
+	if read := mmu.ReadByte(0xFF35); read != 0xFF {
+		t.Errorf("Wave RAM read as %X between samples, want FF", read)
+	}
+	mmu.WriteByte(0xFF35, 0xAA)
+
+	// Reads just after the channel reads wave RAM reach the byte it is playing
+	apu.Step(4)
+	if read := mmu.ReadByte(0xFF35); read != 0 {
+		t.Errorf("Wave RAM read as %X as the channel read it, want 0", read)
+	}
+	mmu.WriteByte(0xFF35, 0xBB)
+	if apu.wave.ram[0] != 0xBB || apu.wave.ram[5] != 5 {
+		t.Errorf("Write as the channel read wave RAM set %X and %X, want BB and 5", apu.wave.ram[0], apu.wave.ram[5])
+	}
+}
+
+func TestNoiseLFSR(t *testing.T) {
+	tables := []struct {
+		width7 bool
+		// The first LFSR values after triggering, and the length of the sequence
+		values []uint16
+		period int
+	}{
+		{false, []uint16{0x7FFF, 0x3FFF, 0x1FFF, 0x0FFF}, 32767},
+		{true, []uint16{0x7FFF, 0x3FBF, 0x1F9F, 0x0F8F}, 127},
+	}
+
+	for _, table := range tables {
+		c := NoiseChannel{}
+		c.Write(2, 0xF0)
+		if table.width7 {
+			c.Write(3, 0x08)
+		} else {
+			c.Write(3, 0x00)
+		}
+		c.Write(4, 0x80)
+
+		for i, value := range table.values {
+			if c.lfsr != value {
+				t.Errorf("7-bit %v: LFSR was %X after %d clocks, want %X", table.width7, c.lfsr, i, value)
+			}
+			c.ClockLFSR()
+		}
+
+		// Once the all ones state passes through the short loop, the sequence repeats with the period
+		start := c.lfsr
+		period := 0
+		for {
+			c.ClockLFSR()
+			period++
+			if c.lfsr == start || period > 32767 {
+				break
+			}
+		}
+		if period != table.period {
+			t.Errorf("7-bit %v: LFSR period was %d, want %d", table.width7, period, table.period)
+		}
+	}
+}
+
+func TestNoiseTiming(t *testing.T) {
+	tables := []struct {
+		nr43   uint8
+		period int
+	}{
+		{0x00, 8},
+		{0x01, 16},
+		{0x07, 112},
+		{0x10, 16},
+		{0x27, 448},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF21, 0xF0)
+		mmu.WriteByte(0xFF22, table.nr43)
+		mmu.WriteByte(0xFF23, 0x80)
+
+		apu.Step(uint64(table.period - 4))
+		if apu.noise.lfsr != 0x7FFF {
+			t.Errorf("NR43 %X: LFSR clocked before %d cycles", table.nr43, table.period)
+		}
+		apu.Step(4)
+		if apu.noise.lfsr != 0x3FFF {
+			t.Errorf("NR43 %X: LFSR not clocked after %d cycles", table.nr43, table.period)
+		}
+		// Bit 0 of the LFSR stays set for the first 15 clocks, so the channel starts silent
+		if apu.Samples()[3] != 0 {
+			t.Errorf("NR43 %X: noise played %d with bit 0 set", table.nr43, apu.Samples()[3])
+		}
+	}
+
+	apu, mmu := newTestAPU()
+	mmu.WriteByte(0xFF21, 0xF0)
+	mmu.WriteByte(0xFF22, 0xE0)
+	mmu.WriteByte(0xFF23, 0x80)
+	apu.Step(1 << 20)
+	if apu.noise.lfsr != 0x7FFF {
+		t.Error("LFSR clocked with a shift of 14")
+	}
+
+	// Length and envelope work like the square channels
+	mmu.WriteByte(0xFF20, 0x3E)
+	mmu.WriteByte(0xFF21, 0xF1)
+	mmu.WriteByte(0xFF23, 0xC0)
+	clockFrameSequencer(apu, 3)
+	if apu.noise.enabled || apu.noise.volume != 15 {
+		t.Errorf("Noise enabled %v with volume %d after its length ran out, want false and 15", apu.noise.enabled, apu.noise.volume)
+	}
+}
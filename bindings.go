@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BINDINGSPATH is where the frontend looks for a bindings config
+const BINDINGSPATH = "./data/bindings.json"
+
+// Hotkeys are actions handled by the frontend rather than passed on to the joypad
+const (
+	HOTKEYQUIT        = "quit"
+	HOTKEYPAUSE       = "pause"
+	HOTKEYFASTFORWARD = "fastforward"
+	HOTKEYREWIND      = "rewind"
+	HOTKEYSAVESTATE   = "savestate"
+	HOTKEYLOADSTATE   = "loadstate"
+	HOTKEYSCREENSHOT  = "screenshot"
+	// HOTKEYSLOT followed by a slot number, like "slot3", selects the slot the save state hotkeys use
+	HOTKEYSLOT = "slot"
+)
+
+// buttonNames maps the names used for joypad buttons in bindings to the buttons
+var buttonNames = map[string]uint8{
+	"right":  BUTTONRIGHT,
+	"left":   BUTTONLEFT,
+	"up":     BUTTONUP,
+	"down":   BUTTONDOWN,
+	"a":      BUTTONA,
+	"b":      BUTTONB,
+	"select": BUTTONSELECT,
+	"start":  BUTTONSTART,
+}
+
+// hotkeyNames lists every hotkey
+var hotkeyNames = map[string]bool{
+	HOTKEYQUIT:        true,
+	HOTKEYPAUSE:       true,
+	HOTKEYFASTFORWARD: true,
+	HOTKEYREWIND:      true,
+	HOTKEYSAVESTATE:   true,
+	HOTKEYLOADSTATE:   true,
+	HOTKEYSCREENSHOT:  true,
+}
+
+// Bindings maps keyboard keys and game controller inputs to joypad buttons and hotkeys.
+// Each input is bound to the name of a button, like "a" or "start", or of a hotkey, like "pause".
+// Inputs are named the way SDL names them, so the config file is readable and independent of SDL's key codes.
+type Bindings struct {
+	// Keys maps key names, like "Up", "X" or "Return", to actions
+	Keys map[string]string `json:"keys"`
+	// ControllerButtons maps controller button names, like "a", "dpup" or "start", to actions
+	ControllerButtons map[string]string `json:"controller_buttons"`
+	// ControllerAxes maps a controller axis name and direction, like "leftx+" or "lefty-", to actions
+	ControllerAxes map[string]string `json:"controller_axes"`
+	// Deadzone is the fraction of an axis' range around its center which is ignored
+	Deadzone float64 `json:"deadzone"`
+}
+
+// DefaultBindings returns the bindings used when no config file exists.
+func DefaultBindings() Bindings {
+	return Bindings{
+		Keys: map[string]string{
+			"Right":     "right",
+			"Left":      "left",
+			"Up":        "up",
+			"Down":      "down",
+			"X":         "a",
+			"Z":         "b",
+			"Backspace": "select",
+			"Return":    "start",
+			"Escape":    HOTKEYQUIT,
+			"P":         HOTKEYPAUSE,
+			"Space":     HOTKEYFASTFORWARD,
+			"R":         HOTKEYREWIND,
+			"1":         HOTKEYSLOT + "1",
+			"2":         HOTKEYSLOT + "2",
+			"3":         HOTKEYSLOT + "3",
+			"4":         HOTKEYSLOT + "4",
+			"5":         HOTKEYSLOT + "5",
+			"6":         HOTKEYSLOT + "6",
+			"7":         HOTKEYSLOT + "7",
+			"8":         HOTKEYSLOT + "8",
+			"9":         HOTKEYSLOT + "9",
+			"0":         HOTKEYSLOT + "0",
+			"F5":        HOTKEYSAVESTATE,
+			"F7":        HOTKEYLOADSTATE,
+			"F12":       HOTKEYSCREENSHOT,
+		},
+		ControllerButtons: map[string]string{
+			"dpright":       "right",
+			"dpleft":        "left",
+			"dpup":          "up",
+			"dpdown":        "down",
+			"a":             "a",
+			"b":             "b",
+			"back":          "select",
+			"start":         "start",
+			"rightshoulder": HOTKEYFASTFORWARD,
+			"leftshoulder":  HOTKEYREWIND,
+		},
+		ControllerAxes: map[string]string{
+			"leftx+": "right",
+			"leftx-": "left",
+			"lefty-": "up",
+			"lefty+": "down",
+		},
+		Deadzone: 0.25,
+	}
+}
+
+// LoadBindings reads bindings from a JSON file.
+// Any section missing from the file keeps its default, and a missing file gives the default bindings.
+func LoadBindings(path string) (Bindings, error) {
+	defaults := DefaultBindings()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return defaults, nil
+	}
+	if err != nil {
+		return defaults, err
+	}
+
+	// Unmarshalling over the defaults keeps the default deadzone unless the file sets one, even to 0. The maps are
+	// cleared first since unmarshalling into a map adds to it, and each section in the file replaces the default.
+	b := defaults
+	b.Keys, b.ControllerButtons, b.ControllerAxes = nil, nil, nil
+	if err := json.Unmarshal(data, &b); err != nil {
+		return defaults, fmt.Errorf("reading bindings from %s: %s", path, err)
+	}
+	if b.Keys == nil {
+		b.Keys = defaults.Keys
+	}
+	if b.ControllerButtons == nil {
+		b.ControllerButtons = defaults.ControllerButtons
+	}
+	if b.ControllerAxes == nil {
+		b.ControllerAxes = defaults.ControllerAxes
+	}
+
+	if err := b.Validate(); err != nil {
+		return defaults, fmt.Errorf("reading bindings from %s: %s", path, err)
+	}
+	return b, nil
+}
+
+// Validate checks that every input is bound to a known action and the deadzone is in range.
+func (b Bindings) Validate() error {
+	for _, section := range []map[string]string{b.Keys, b.ControllerButtons, b.ControllerAxes} {
+		// Sort the inputs so the same config always reports the same error
+		inputs := make([]string, 0, len(section))
+		for input := range section {
+			inputs = append(inputs, input)
+		}
+		sort.Strings(inputs)
+
+		for _, input := range inputs {
+			if _, _, ok := ParseAction(section[input]); !ok {
+				return fmt.Errorf("%q is bound to unknown action %q", input, section[input])
+			}
+		}
+	}
+
+	for axis := range b.ControllerAxes {
+		if !strings.HasSuffix(axis, "+") && !strings.HasSuffix(axis, "-") {
+			return fmt.Errorf("axis %q needs a direction, + or -", axis)
+		}
+	}
+
+	if b.Deadzone < 0 || b.Deadzone >= 1 {
+		return fmt.Errorf("deadzone %v is not between 0 and 1", b.Deadzone)
+	}
+	return nil
+}
+
+// ParseAction looks up the name of an action. It returns the button for a joypad button, or the hotkey name
+// for a hotkey.
+func ParseAction(name string) (button uint8, hotkey string, ok bool) {
+	if button, ok := buttonNames[name]; ok {
+		return button, "", true
+	}
+	if _, ok := SlotHotkey(name); ok || hotkeyNames[name] {
+		return 0, name, true
+	}
+	return 0, "", false
+}
+
+// SlotHotkey returns the save state slot selected by a slot hotkey, like 3 for "slot3".
+func SlotHotkey(hotkey string) (slot int, ok bool) {
+	slot, err := strconv.Atoi(strings.TrimPrefix(hotkey, HOTKEYSLOT))
+	if err != nil || slot < 0 || slot >= STATESLOTS || hotkey != HOTKEYSLOT+strconv.Itoa(slot) {
+		return 0, false
+	}
+	return slot, true
+}
+
+// AxisDirection returns 1 or -1 when an axis is pushed past the deadzone in the positive or negative direction,
+// and 0 otherwise.
+func (b Bindings) AxisDirection(value int16) int {
+	threshold := b.Deadzone * 32767
+	switch {
+	case float64(value) > threshold:
+		return 1
+	case float64(value) < -threshold:
+		return -1
+	default:
+		return 0
+	}
+}
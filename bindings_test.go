@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultBindings(t *testing.T) {
+	b := DefaultBindings()
+	if err := b.Validate(); err != nil {
+		t.Errorf("Default bindings are invalid: %s", err)
+	}
+
+	// Every button should be reachable from the keyboard and a controller
+	for name, button := range buttonNames {
+		found := [2]bool{}
+		for i, section := range []map[string]string{b.Keys, b.ControllerButtons} {
+			for _, action := range section {
+				if bound, hotkey, _ := ParseAction(action); hotkey == "" && bound == button {
+					found[i] = true
+				}
+			}
+		}
+		if !found[0] || !found[1] {
+			t.Errorf("Button %s bound on keyboard %v, on controller %v", name, found[0], found[1])
+		}
+	}
+}
+
+func TestLoadBindings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		config string
+		valid  bool
+		// Expected action of the "Up" key and deadzone
+		up       string
+		deadzone float64
+	}{
+		{"", true, "up", 0.25},
+		{`{"keys": {"W": "up", "K": "a"}}`, true, "", 0.25},
+		{`{"deadzone": 0.5}`, true, "up", 0.5},
+		{`{"deadzone": 0}`, true, "up", 0},
+		{`{"keys": {"Up": "jump"}}`, false, "up", 0.25},
+		{`{"controller_axes": {"leftx": "left"}}`, false, "up", 0.25},
+		{`{"deadzone": 1.5}`, false, "up", 0.25},
+		{`{"keys": `, false, "up", 0.25},
+	}
+
+	for i, table := range tables {
+		path := filepath.Join(dir, "missing.json")
+		if table.config != "" {
+			path = filepath.Join(dir, "bindings.json")
+			check(ioutil.WriteFile(path, []byte(table.config), 0644))
+		}
+
+		b, err := LoadBindings(path)
+		if (err == nil) != table.valid {
+			t.Errorf("Config %d gave error %v", i, err)
+		}
+		if b.Keys["Up"] != table.up || b.Deadzone != table.deadzone {
+			t.Errorf("Config %d bound Up to %q with deadzone %v, want %q and %v", i, b.Keys["Up"], b.Deadzone, table.up, table.deadzone)
+		}
+	}
+}
+
+func TestAxisDirection(t *testing.T) {
+	b := DefaultBindings()
+
+	tables := []struct {
+		value     int16
+		direction int
+	}{
+		{0, 0},
+		{8000, 0},
+		{-8000, 0},
+		{9000, 1},
+		{-9000, -1},
+		{32767, 1},
+		{-32768, -1},
+	}
+
+	for _, table := range tables {
+		if direction := b.AxisDirection(table.value); direction != table.direction {
+			t.Errorf("Axis value %d gave direction %d, want %d", table.value, direction, table.direction)
+		}
+	}
+}
+
+func TestSlotHotkey(t *testing.T) {
+	tables := []struct {
+		hotkey string
+		slot   int
+		ok     bool
+	}{
+		{"slot0", 0, true},
+		{"slot9", 9, true},
+		{"slot10", 0, false},
+		{"slot-1", 0, false},
+		{"slot+1", 0, false},
+		{"slot", 0, false},
+		{"pause", 0, false},
+	}
+
+	for _, table := range tables {
+		slot, ok := SlotHotkey(table.hotkey)
+		if slot != table.slot || ok != table.ok {
+			t.Errorf("%q selected slot %d, %t, want %d, %t", table.hotkey, slot, ok, table.slot, table.ok)
+		}
+		if _, hotkey, ok := ParseAction(table.hotkey); ok != (table.ok || table.hotkey == "pause") || (ok && hotkey != table.hotkey) {
+			t.Errorf("%q parsed as hotkey %q, %t", table.hotkey, hotkey, ok)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import "sort"
+
+// Hardware models, which differ in the state their boot ROM leaves behind
+const (
+	MODELDMG0 = "dmg0"
+	MODELDMG  = "dmg"
+	MODELMGB  = "mgb"
+	MODELSGB  = "sgb"
+	MODELCGB  = "cgb"
+)
+
+// BootState is the state the boot ROM of a model leaves the CPU registers and divider in when it jumps to the
+// cartridge at 0x0100.
+type BootState struct {
+	AF, BC, DE, HL, SP uint16
+	// DIV is the upper byte of the divider
+	DIV uint8
+	// headerFlags sets the H and C flags unless the header checksum is 0, as the DMG and MGB boot ROMs do
+	headerFlags bool
+}
+
+// BOOTSTATES are the post-boot states of each model. The DIV value isn't known for the SGB and CGB, so theirs
+// starts at 0. The CGB values are the ones left when it runs a DMG game, since only the DMG is emulated.
+var BOOTSTATES = map[string]BootState{
+	MODELDMG0: {AF: 0x0100, BC: 0xFF13, DE: 0x00C1, HL: 0x8403, SP: 0xFFFE, DIV: 0x18},
+	MODELDMG:  {AF: 0x0180, BC: 0x0013, DE: 0x00D8, HL: 0x014D, SP: 0xFFFE, DIV: 0xAB, headerFlags: true},
+	MODELMGB:  {AF: 0xFF80, BC: 0x0013, DE: 0x00D8, HL: 0x014D, SP: 0xFFFE, DIV: 0xAB, headerFlags: true},
+	MODELSGB:  {AF: 0x0100, BC: 0x0014, DE: 0x0000, HL: 0xC060, SP: 0xFFFE},
+	MODELCGB:  {AF: 0x1180, BC: 0x0000, DE: 0x0008, HL: 0x007C, SP: 0xFFFE},
+}
+
+// POSTBOOTIO holds the values the boot ROM leaves in the I/O registers and IE, in the order to write them when
+// skipping it. NR52 comes first as the sound registers can't be written while the APU is off, and writing NR14
+// retriggers channel 1, which is still playing the end of the boot sound. IF comes after the LCD is switched on,
+// so only the VBlank interrupt the boot ROM leaves pending is set.
+var POSTBOOTIO = []struct {
+	address uint16
+	value   uint8
+}{
+	{0xFF26, 0xF1},
+	{0xFF01, 0x00}, {0xFF02, 0x7E},
+	{0xFF05, 0x00}, {0xFF06, 0x00}, {0xFF07, 0x00},
+	{0xFF10, 0x80}, {0xFF11, 0xBF}, {0xFF12, 0xF3}, {0xFF14, 0xBF},
+	{0xFF16, 0x3F}, {0xFF17, 0x00}, {0xFF19, 0xBF},
+	{0xFF1A, 0x7F}, {0xFF1B, 0xFF}, {0xFF1C, 0x9F}, {0xFF1E, 0xBF},
+	{0xFF20, 0xFF}, {0xFF21, 0x00}, {0xFF22, 0x00}, {0xFF23, 0xBF},
+	{0xFF24, 0x77}, {0xFF25, 0xF3},
+	{0xFF40, 0x91}, {0xFF42, 0x00}, {0xFF43, 0x00}, {0xFF45, 0x00},
+	{0xFF47, 0xFC}, {0xFF48, 0xFF}, {0xFF49, 0xFF}, {0xFF4A, 0x00}, {0xFF4B, 0x00},
+	{0xFF0F, 0xE1}, {0xFF50, 0x01}, {0xFFFF, 0x00},
+}
+
+// BOOTLOGOTM is the trademark symbol the boot ROM draws after the logo
+var BOOTLOGOTM = []uint8{0x3C, 0x42, 0xB9, 0xA5, 0xB9, 0xA5, 0x42, 0x3C}
+
+// drawBootLogo writes the tiles and tile map the boot ROM leaves in VRAM for the logo in a cartridge header.
+// Each nibble of the logo is a row of 4 pixels, which the boot ROM doubles in width and height into tiles 1-24.
+// The trademark symbol is tile 25. Only the low bitplane is set, so the logo is drawn in color 1.
+func drawBootLogo(mmu *MMU, logo []uint8) {
+	address := uint16(0x8010)
+	for _, b := range logo {
+		for _, nibble := range []uint8{b >> 4, b & 0x0F} {
+			row := uint8(0)
+			for bit := uint8(0); bit < 4; bit++ {
+				if nibble&(1<<bit) != 0 {
+					row |= 3 << (bit * 2)
+				}
+			}
+			mmu.WriteByte(address, row)
+			mmu.WriteByte(address+2, row)
+			address += 4
+		}
+	}
+	for _, row := range BOOTLOGOTM {
+		mmu.WriteByte(address, row)
+		address += 2
+	}
+
+	// The top half of the logo is tiles 1-12 from 0x9904, the bottom half tiles 13-24 from 0x9924, and the
+	// trademark symbol follows the top half
+	for i := uint16(0); i < 12; i++ {
+		mmu.WriteByte(0x9904+i, uint8(1+i))
+		mmu.WriteByte(0x9924+i, uint8(13+i))
+	}
+	mmu.WriteByte(0x9910, 25)
+}
+
+// HeaderFlags returns the F register of a boot state for a cartridge with a given header checksum.
+func (b BootState) HeaderFlags(headerChecksum uint8) uint8 {
+	flags := uint8(b.AF)
+	if b.headerFlags && headerChecksum != 0 {
+		flags |= BitVal(H) | BitVal(C)
+	}
+	return flags
+}
+
+// modelNames returns the names of the models in alphabetical order.
+func modelNames() []string {
+	names := make([]string, 0, len(BOOTSTATES))
+	for name := range BOOTSTATES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
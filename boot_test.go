@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSkipBoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	tables := []struct {
+		model      string
+		af, bc, hl uint16
+		div        uint8
+	}{
+		{MODELDMG0, 0x0100, 0xFF13, 0x8403, 0x18},
+		{MODELDMG, 0x01B0, 0x0013, 0x014D, 0xAB},
+		{MODELMGB, 0xFFB0, 0x0013, 0x014D, 0xAB},
+		{MODELSGB, 0x0100, 0x0014, 0xC060, 0x00},
+		{MODELCGB, 0x1180, 0x0000, 0x007C, 0x00},
+	}
+
+	for _, table := range tables {
+		gb := newTestGameBoy(dir, STOREPROGRAM, table.model)
+
+		c := gb.cpu
+		if c.AF.word != table.af || c.BC.word != table.bc || c.HL.word != table.hl || c.SP.word != 0xFFFE || c.PC.word != 0x0100 {
+			t.Errorf("%s: started with AF=%04X BC=%04X HL=%04X SP=%04X PC=%04X", table.model, c.AF.word, c.BC.word, c.HL.word, c.SP.word, c.PC.word)
+		}
+		if div := gb.mmu.ReadByte(0xFF04); div != table.div {
+			t.Errorf("%s: DIV started at %X, want %X", table.model, div, table.div)
+		}
+		if nr52, lcdc, bgp := gb.mmu.ReadByte(0xFF26), gb.mmu.ReadByte(0xFF40), gb.mmu.ReadByte(0xFF47); nr52 != 0xF1 || lcdc != 0x91 || bgp != 0xFC {
+			t.Errorf("%s: started with NR52=%X LCDC=%X BGP=%X", table.model, nr52, lcdc, bgp)
+		}
+		// The logo starts with $CE, whose nibbles are drawn as two rows of $F0 then two of $FC
+		if tile, row, tm, tilemap := gb.mmu.ReadByte(0x8010), gb.mmu.ReadByte(0x8014), gb.mmu.ReadByte(0x8192), gb.mmu.ReadByte(0x9904); tile != 0xF0 || row != 0xFC || tm != 0x42 || tilemap != 1 {
+			t.Errorf("%s: logo rows %X %X, trademark row %X, first tile map entry %X", table.model, tile, row, tm, tilemap)
+		}
+		if top, bottom, tm := gb.mmu.ReadByte(0x990F), gb.mmu.ReadByte(0x992F), gb.mmu.ReadByte(0x9910); top != 12 || bottom != 24 || tm != 25 {
+			t.Errorf("%s: tile map ends with tiles %d and %d and trademark %d", table.model, top, bottom, tm)
+		}
+		// IF and the serial registers aren't owned by any component, so they start out random unless written
+		if ifReg, sb, sc := gb.mmu.ReadByte(IFADDR), gb.mmu.ReadByte(0xFF01), gb.mmu.ReadByte(0xFF02); ifReg != 0xE1 || sb != 0x00 || sc != 0x7E {
+			t.Errorf("%s: started with IF=%X SB=%X SC=%X", table.model, ifReg, sb, sc)
+		}
+
+		h := &(Headless{})
+		h.Start(gb)
+		h.Step()
+		if gb.mmu.ReadByte(0x0000) != gb.cartridge[0x0000] || gb.mmu.ReadByte(0xC000) != 0x42 {
+			t.Errorf("%s: the boot ROM was mapped or the cartridge didn't run in the first frame", table.model)
+		}
+	}
+
+	gb := newTestGameBoy(dir, STOREPROGRAM, MODELDMG)
+	if err := gb.cpu.CheckMemoryAfterBoot(); err != nil {
+		t.Errorf("Skipped boot doesn't pass the post-boot check: %s", err)
+	}
+	if err := gb.SetSkipBoot("gba"); err == nil {
+		t.Error("Skipping the boot of an unknown model didn't give an error")
+	}
+}
+
+func TestBootHeaderFlags(t *testing.T) {
+	tables := []struct {
+		model    string
+		checksum uint8
+		flags    uint8
+	}{
+		{MODELDMG, 0x00, 0x80},
+		{MODELDMG, 0x4D, 0xB0},
+		{MODELMGB, 0x00, 0x80},
+		{MODELSGB, 0x4D, 0x00},
+	}
+
+	for _, table := range tables {
+		if flags := BOOTSTATES[table.model].HeaderFlags(table.checksum); flags != table.flags {
+			t.Errorf("%s with header checksum %X: flags %X, want %X", table.model, table.checksum, flags, table.flags)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ROMBANKSIZE and RAMBANKSIZE are the sizes of the switchable cartridge memory banks.
+const (
+	ROMBANKSIZE = 0x4000
+	RAMBANKSIZE = 0x2000
+)
+
+// MBC2RAMSIZE is the number of 4-bit RAM cells built into the MBC2.
+const MBC2RAMSIZE = 0x200
+
+// Cartridge is the ROM, RAM and memory bank controller of a game cartridge.
+// The MMU routes 0x0000-0x7FFF to the ROM functions and 0xA000-0xBFFF to the RAM functions.
+type Cartridge interface {
+	// ReadROM returns the byte at an address in 0x0000-0x7FFF from the currently mapped ROM banks.
+	ReadROM(address uint16) uint8
+	// WriteROM handles a write to 0x0000-0x7FFF, which sets the memory bank controller registers.
+	WriteROM(address uint16, value uint8)
+	// ReadRAM returns the byte at an address in 0xA000-0xBFFF from the currently mapped RAM bank.
+	ReadRAM(address uint16) uint8
+	// WriteRAM writes a byte to an address in 0xA000-0xBFFF of the currently mapped RAM bank.
+	WriteRAM(address uint16, value uint8)
+	// SerializeState writes or reads the bank registers and RAM for a save state.
+	SerializeState(s *StateSerializer)
+}
+
+// NewCartridge creates the Cartridge matching the cartridge type in the header of the ROM data.
+func NewCartridge(data []uint8) (Cartridge, error) {
+	header, err := ParseCartridgeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	ramSize := header.RAMSize
+
+	switch header.CartridgeType {
+	case 0x00, 0x08, 0x09:
+		return &ROMOnly{rom: data, SaveRAM: SaveRAM{ram: make([]uint8, ramSize)}}, nil
+	case 0x01, 0x02, 0x03:
+		return &MBC1{rom: data, SaveRAM: SaveRAM{ram: make([]uint8, ramSize)}, romBank: 1, multicart: IsMBC1M(data)}, nil
+	case 0x0F, 0x10, 0x11, 0x12, 0x13:
+		hasRTC := header.CartridgeType == 0x0F || header.CartridgeType == 0x10
+		return &MBC3{rom: data, SaveRAM: SaveRAM{ram: make([]uint8, ramSize)}, romBank: 1, hasRTC: hasRTC, rtc: RTC{clock: SystemClock{}}}, nil
+	case 0x05, 0x06:
+		// MBC2 has 512 half-byte RAM cells built in, so the header RAM size is 0
+		return &MBC2{rom: data, SaveRAM: SaveRAM{ram: make([]uint8, MBC2RAMSIZE)}, romBank: 1}, nil
+	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E:
+		hasRumble := header.CartridgeType >= 0x1C
+		return &MBC5{rom: data, SaveRAM: SaveRAM{ram: make([]uint8, ramSize)}, romBank: 1, hasRumble: hasRumble}, nil
+	default:
+		return nil, fmt.Errorf("cartridge type %s not supported", header.TypeName())
+	}
+}
+
+// RAMSize returns the size in bytes of the cartridge RAM for the RAM size code at 0x0149 in the header.
+func RAMSize(code uint8) (int, error) {
+	switch code {
+	case 0x00:
+		return 0, nil
+	case 0x01:
+		return 0x800, nil
+	case 0x02:
+		return 0x2000, nil
+	case 0x03:
+		return 0x8000, nil
+	case 0x04:
+		return 0x20000, nil
+	case 0x05:
+		return 0x10000, nil
+	default:
+		return 0, fmt.Errorf("cartridge RAM size %X not supported", code)
+	}
+}
+
+// IsMBC1M returns true if the ROM is an MBC1 multicart.
+// These are 1 MB carts made of four 256 KB games, each with its own header, which is detected by the
+// Nintendo logo appearing again at the start of bank 0x10.
+func IsMBC1M(data []uint8) bool {
+	if len(data) != 0x100000 {
+		return false
+	}
+	second := 0x10*ROMBANKSIZE + 0x0104
+	return bytes.Equal(data[0x0104:0x0134], data[second:second+0x30])
+}
+
+// readBank returns the byte at an offset into a bank of data, wrapping bank numbers larger than the data.
+func readBank(data []uint8, bankSize int, bank int, offset uint16) uint8 {
+	if len(data) == 0 {
+		return 0xFF
+	}
+	return data[(bank*bankSize+int(offset))%len(data)]
+}
+
+// writeBank writes a byte to an offset into a bank of data, wrapping bank numbers larger than the data.
+func writeBank(data []uint8, bankSize int, bank int, offset uint16, value uint8) {
+	if len(data) == 0 {
+		return
+	}
+	data[(bank*bankSize+int(offset))%len(data)] = value
+}
+
+// ROMOnly is a cartridge with 32 KB of ROM, and optionally up to 8 KB of RAM, and no memory bank controller.
+type ROMOnly struct {
+	rom []uint8
+	SaveRAM
+}
+
+// ReadROM returns the byte at an address of the ROM.
+func (r *ROMOnly) ReadROM(address uint16) uint8 {
+	if int(address) < len(r.rom) {
+		return r.rom[address]
+	}
+	return 0xFF
+}
+
+// WriteROM ignores writes since there are no registers to set.
+func (r *ROMOnly) WriteROM(address uint16, value uint8) {
+}
+
+// ReadRAM returns the byte at an address of the RAM.
+func (r *ROMOnly) ReadRAM(address uint16) uint8 {
+	return readBank(r.ram, RAMBANKSIZE, 0, address-0xA000)
+}
+
+// WriteRAM writes a byte to an address of the RAM.
+func (r *ROMOnly) WriteRAM(address uint16, value uint8) {
+	r.writeRAMBank(0, address-0xA000, value)
+}
+
+// SerializeState writes or reads the RAM.
+func (r *ROMOnly) SerializeState(s *StateSerializer) {
+	r.SaveRAM.SerializeState(s)
+}
+
+// MBC1 is a cartridge with an MBC1 memory bank controller, supporting up to 2 MB of ROM and 32 KB of RAM.
+type MBC1 struct {
+	rom []uint8
+	SaveRAM
+
+	ramEnabled bool
+	// romBank is the 5-bit register at 0x2000-0x3FFF, bank2 is the 2-bit register at 0x4000-0x5FFF
+	// used as either the upper ROM bank bits or the RAM bank, and mode is the banking mode at 0x6000-0x7FFF.
+	romBank uint8
+	bank2   uint8
+	mode    uint8
+
+	// multicart MBC1M carts only connect 4 bits of the ROM bank register, so bank2 starts at bit 4.
+	multicart bool
+}
+
+// upperBank returns the value of bank2 shifted into place above the ROM bank register.
+func (m *MBC1) upperBank() int {
+	if m.multicart {
+		return int(m.bank2) << 4
+	}
+	return int(m.bank2) << 5
+}
+
+// ReadROM returns the byte at an address from bank 0 (or the bank2 selected bank in mode 1) for 0x0000-0x3FFF
+// and from the selected ROM bank for 0x4000-0x7FFF.
+func (m *MBC1) ReadROM(address uint16) uint8 {
+	if address < 0x4000 {
+		bank := 0
+		if m.mode == 1 {
+			bank = m.upperBank()
+		}
+		return readBank(m.rom, ROMBANKSIZE, bank, address)
+	}
+
+	lowerBank := int(m.romBank)
+	if m.multicart {
+		lowerBank &= 0xF
+	}
+	return readBank(m.rom, ROMBANKSIZE, m.upperBank()|lowerBank, address-0x4000)
+}
+
+// WriteROM sets the RAM enable, ROM bank, RAM/upper ROM bank and banking mode registers.
+func (m *MBC1) WriteROM(address uint16, value uint8) {
+	switch {
+	case address < 0x2000:
+		m.ramEnabled = value&0xF == 0xA
+	case address < 0x4000:
+		// Bank 0 can't be selected here, writing 0 selects bank 1 instead.
+		m.romBank = value & 0x1F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case address < 0x6000:
+		m.bank2 = value & 0x3
+	default:
+		m.mode = value & 0x1
+	}
+}
+
+// ramBank returns the selected RAM bank, which is always 0 in banking mode 0.
+func (m *MBC1) ramBank() int {
+	if m.mode == 1 {
+		return int(m.bank2)
+	}
+	return 0
+}
+
+// ReadRAM returns the byte at an address of the selected RAM bank, or 0xFF if the RAM is disabled.
+func (m *MBC1) ReadRAM(address uint16) uint8 {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return readBank(m.ram, RAMBANKSIZE, m.ramBank(), address-0xA000)
+}
+
+// WriteRAM writes a byte to an address of the selected RAM bank if the RAM is enabled.
+func (m *MBC1) WriteRAM(address uint16, value uint8) {
+	if !m.ramEnabled {
+		return
+	}
+	m.writeRAMBank(m.ramBank(), address-0xA000, value)
+}
+
+// SerializeState writes or reads the bank registers and RAM.
+func (m *MBC1) SerializeState(s *StateSerializer) {
+	m.SaveRAM.SerializeState(s)
+	s.Bool(&m.ramEnabled)
+	s.Uint8(&m.romBank)
+	s.Uint8(&m.bank2)
+	s.Uint8(&m.mode)
+}
+
+// MBC3 is a cartridge with an MBC3 memory bank controller, supporting up to 2 MB of ROM, 32 KB of RAM,
+// and optionally a real-time clock.
+type MBC3 struct {
+	rom []uint8
+	SaveRAM
+
+	ramEnabled bool
+	romBank    uint8
+	// ramBank selects a RAM bank with 0x00-0x03, or an RTC register with 0x08-0x0C
+	ramBank uint8
+	// latch is the last value written to 0x6000-0x7FFF. Writing 0 then 1 latches the clock.
+	latch uint8
+
+	hasRTC bool
+	rtc    RTC
+}
+
+// SaveData returns the RAM, followed by the RTC footer if the cartridge has a clock.
+func (m *MBC3) SaveData() []uint8 {
+	data := m.SaveRAM.SaveData()
+	if m.hasRTC {
+		data = append(data, m.rtc.Footer()...)
+	}
+	return data
+}
+
+// LoadSaveData restores the RAM, and the RTC if the save data includes its footer.
+func (m *MBC3) LoadSaveData(data []uint8) error {
+	if err := m.SaveRAM.LoadSaveData(data); err != nil {
+		return err
+	}
+	if footer := data[len(m.ram):]; m.hasRTC && len(footer) > 0 {
+		return m.rtc.LoadFooter(footer)
+	}
+	return nil
+}
+
+// SerializeState writes or reads the bank registers, RAM and clock.
+func (m *MBC3) SerializeState(s *StateSerializer) {
+	m.SaveRAM.SerializeState(s)
+	s.Bool(&m.ramEnabled)
+	s.Uint8(&m.romBank)
+	s.Uint8(&m.ramBank)
+	s.Uint8(&m.latch)
+	if m.hasRTC {
+		m.rtc.SerializeState(s)
+	}
+}
+
+// ReadROM returns the byte at an address from bank 0 for 0x0000-0x3FFF and from the selected ROM bank for
+// 0x4000-0x7FFF.
+func (m *MBC3) ReadROM(address uint16) uint8 {
+	if address < 0x4000 {
+		return readBank(m.rom, ROMBANKSIZE, 0, address)
+	}
+	return readBank(m.rom, ROMBANKSIZE, int(m.romBank), address-0x4000)
+}
+
+// WriteROM sets the RAM and timer enable, ROM bank, RAM bank or RTC register select, and clock latch registers.
+func (m *MBC3) WriteROM(address uint16, value uint8) {
+	switch {
+	case address < 0x2000:
+		m.ramEnabled = value&0xF == 0xA
+	case address < 0x4000:
+		m.romBank = value & 0x7F
+		if m.romBank == 0 {
+			m.romBank = 1
+		}
+	case address < 0x6000:
+		m.ramBank = value & 0xF
+	default:
+		if m.hasRTC && m.latch == 0 && value == 1 {
+			m.rtc.Latch()
+		}
+		m.latch = value
+	}
+}
+
+// ReadRAM returns the byte at an address of the selected RAM bank or the selected latched RTC register,
+// or 0xFF if the RAM and timer are disabled.
+func (m *MBC3) ReadRAM(address uint16) uint8 {
+	switch {
+	case !m.ramEnabled:
+		return 0xFF
+	case m.ramBank <= 0x03:
+		return readBank(m.ram, RAMBANKSIZE, int(m.ramBank), address-0xA000)
+	case m.hasRTC && m.ramBank >= RTCSECONDS && m.ramBank <= RTCDAYHIGH:
+		return m.rtc.Read(m.ramBank)
+	default:
+		return 0xFF
+	}
+}
+
+// WriteRAM writes a byte to an address of the selected RAM bank or to the selected RTC register,
+// if the RAM and timer are enabled.
+func (m *MBC3) WriteRAM(address uint16, value uint8) {
+	switch {
+	case !m.ramEnabled:
+	case m.ramBank <= 0x03:
+		m.writeRAMBank(int(m.ramBank), address-0xA000, value)
+	case m.hasRTC && m.ramBank >= RTCSECONDS && m.ramBank <= RTCDAYHIGH:
+		m.rtc.Write(m.ramBank, value)
+		m.writes++
+	}
+}
+
+// MBC5 is a cartridge with an MBC5 memory bank controller, supporting up to 8 MB of ROM, 128 KB of RAM,
+// and optionally a rumble motor.
+type MBC5 struct {
+	rom []uint8
+	SaveRAM
+
+	ramEnabled bool
+	// romBank is 9 bits, the low 8 set by 0x2000-0x2FFF and bit 8 by 0x3000-0x3FFF. Unlike MBC1 and MBC3, bank 0
+	// can be mapped into 0x4000-0x7FFF.
+	romBank uint16
+	ramBank uint8
+
+	// Rumble carts use bit 3 of the RAM bank register to drive the motor instead of selecting a bank.
+	hasRumble     bool
+	rumble        bool
+	rumbleHandler func(on bool)
+}
+
+// SetRumbleHandler sets a function which is called whenever the game switches the rumble motor on or off.
+func (m *MBC5) SetRumbleHandler(handler func(on bool)) {
+	m.rumbleHandler = handler
+}
+
+// SerializeState writes or reads the bank registers and RAM, switching the rumble motor to its restored state.
+func (m *MBC5) SerializeState(s *StateSerializer) {
+	m.SaveRAM.SerializeState(s)
+	s.Bool(&m.ramEnabled)
+	s.Uint16(&m.romBank)
+	s.Uint8(&m.ramBank)
+
+	rumble := m.rumble
+	s.Bool(&rumble)
+	if rumble != m.rumble {
+		m.rumble = rumble
+		if m.rumbleHandler != nil {
+			m.rumbleHandler(rumble)
+		}
+	}
+}
+
+// ReadROM returns the byte at an address from bank 0 for 0x0000-0x3FFF and from the selected ROM bank for
+// 0x4000-0x7FFF.
+func (m *MBC5) ReadROM(address uint16) uint8 {
+	if address < 0x4000 {
+		return readBank(m.rom, ROMBANKSIZE, 0, address)
+	}
+	return readBank(m.rom, ROMBANKSIZE, int(m.romBank), address-0x4000)
+}
+
+// WriteROM sets the RAM enable, ROM bank and RAM bank registers, and the rumble motor on rumble carts.
+func (m *MBC5) WriteROM(address uint16, value uint8) {
+	switch {
+	case address < 0x2000:
+		m.ramEnabled = value == 0x0A
+	case address < 0x3000:
+		m.romBank = m.romBank&0x100 | uint16(value)
+	case address < 0x4000:
+		m.romBank = m.romBank&0xFF | uint16(value&1)<<8
+	case address < 0x6000:
+		if !m.hasRumble {
+			m.ramBank = value & 0xF
+			return
+		}
+		m.ramBank = value & 0x7
+		if rumble := CheckBit(&value, 3); rumble != m.rumble {
+			m.rumble = rumble
+			if m.rumbleHandler != nil {
+				m.rumbleHandler(rumble)
+			}
+		}
+	}
+}
+
+// ReadRAM returns the byte at an address of the selected RAM bank, or 0xFF if the RAM is disabled.
+func (m *MBC5) ReadRAM(address uint16) uint8 {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return readBank(m.ram, RAMBANKSIZE, int(m.ramBank), address-0xA000)
+}
+
+// WriteRAM writes a byte to an address of the selected RAM bank if the RAM is enabled.
+func (m *MBC5) WriteRAM(address uint16, value uint8) {
+	if !m.ramEnabled {
+		return
+	}
+	m.writeRAMBank(int(m.ramBank), address-0xA000, value)
+}
+
+// MBC2 is a cartridge with an MBC2 memory bank controller, supporting up to 256 KB of ROM, with 512x4 bits
+// of RAM built into the controller.
+type MBC2 struct {
+	rom []uint8
+	SaveRAM
+
+	ramEnabled bool
+	romBank    uint8
+}
+
+// SerializeState writes or reads the bank registers and RAM.
+func (m *MBC2) SerializeState(s *StateSerializer) {
+	m.SaveRAM.SerializeState(s)
+	s.Bool(&m.ramEnabled)
+	s.Uint8(&m.romBank)
+}
+
+// ReadROM returns the byte at an address from bank 0 for 0x0000-0x3FFF and from the selected ROM bank for
+// 0x4000-0x7FFF.
+func (m *MBC2) ReadROM(address uint16) uint8 {
+	if address < 0x4000 {
+		return readBank(m.rom, ROMBANKSIZE, 0, address)
+	}
+	return readBank(m.rom, ROMBANKSIZE, int(m.romBank), address-0x4000)
+}
+
+// WriteROM sets the RAM enable register if bit 8 of the address is clear, or the ROM bank register if it is set.
+// Writes to 0x4000-0x7FFF are ignored.
+func (m *MBC2) WriteROM(address uint16, value uint8) {
+	if address >= 0x4000 {
+		return
+	}
+
+	if address&0x100 == 0 {
+		m.ramEnabled = value&0xF == 0xA
+		return
+	}
+	m.romBank = value & 0xF
+	if m.romBank == 0 {
+		m.romBank = 1
+	}
+}
+
+// ReadRAM returns the 4-bit RAM cell at an address, with the upper 4 bits read as 1s.
+// Only the low 9 bits of the address are used, so the RAM repeats through 0xA000-0xBFFF.
+func (m *MBC2) ReadRAM(address uint16) uint8 {
+	if !m.ramEnabled {
+		return 0xFF
+	}
+	return m.ram[address&0x1FF] | 0xF0
+}
+
+// WriteRAM writes the low 4 bits of a value to the RAM cell at an address.
+func (m *MBC2) WriteRAM(address uint16, value uint8) {
+	if !m.ramEnabled {
+		return
+	}
+	m.ram[address&0x1FF] = value & 0xF
+	m.writes++
+}
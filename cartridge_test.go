@@ -0,0 +1,326 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewCartridge(t *testing.T) {
+	if _, err := NewCartridge(newTestROM(2, 0x00, 0x00)); err != nil {
+		t.Errorf("ROM only cartridge gave error %v", err)
+	}
+	if _, err := NewCartridge(newTestROM(2, 0xFC, 0x00)); err == nil {
+		t.Error("Unsupported cartridge type did not give an error")
+	}
+	if _, err := NewCartridge(newTestROM(2, 0x01, 0x09)); err == nil {
+		t.Error("Invalid RAM size did not give an error")
+	}
+	if _, err := NewCartridge(make([]uint8, 0x100)); err == nil {
+		t.Error("Truncated ROM did not give an error")
+	}
+}
+
+func TestMBC1ROMBanking(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(128, 0x01, 0x00))
+	check(err)
+
+	tables := []struct {
+		address uint16
+		value   uint8
+		bank0   uint8
+		bank1   uint8
+	}{
+		{0x2000, 0x00, 0, 1},   // Bank 0 selects bank 1
+		{0x2000, 0x05, 0, 5},   // Lower bank bits
+		{0x3FFF, 0xE7, 0, 7},   // Only 5 bits are used
+		{0x4000, 0x02, 0, 71},  // Upper bank bits in mode 0
+		{0x2000, 0x00, 0, 65},  // 0x40 is unreachable, gives 0x41
+		{0x6000, 0x01, 64, 65}, // Mode 1 maps the upper bits into 0x0000-0x3FFF
+		{0x6000, 0x00, 0, 65},
+	}
+
+	for _, table := range tables {
+		cartridge.WriteROM(table.address, table.value)
+		if bank := cartridge.ReadROM(0x0000); bank != table.bank0 {
+			t.Errorf("After writing %X to $%X, 0x0000 mapped bank %d instead of %d", table.value, table.address, bank, table.bank0)
+		}
+		if bank := cartridge.ReadROM(0x4000); bank != table.bank1 {
+			t.Errorf("After writing %X to $%X, 0x4000 mapped bank %d instead of %d", table.value, table.address, bank, table.bank1)
+		}
+	}
+
+	// Banks past the end of a smaller ROM wrap around.
+	cartridge, err = NewCartridge(newTestROM(8, 0x01, 0x00))
+	check(err)
+	cartridge.WriteROM(0x2000, 0x0B)
+	if bank := cartridge.ReadROM(0x4000); bank != 3 {
+		t.Errorf("Bank 11 of an 8 bank ROM mapped bank %d instead of 3", bank)
+	}
+}
+
+func TestMBC1RAM(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(4, 0x03, 0x03))
+	check(err)
+
+	cartridge.WriteRAM(0xA000, 0x12)
+	if cartridge.ReadRAM(0xA000) != 0xFF {
+		t.Error("RAM is readable before being enabled")
+	}
+
+	cartridge.WriteROM(0x0000, 0x0A)
+	cartridge.WriteRAM(0xA000, 0x12)
+	if cartridge.ReadRAM(0xA000) != 0x12 {
+		t.Errorf("RAM read %X instead of 12 after enabling", cartridge.ReadRAM(0xA000))
+	}
+
+	// RAM banks are only switched in mode 1
+	cartridge.WriteROM(0x4000, 0x02)
+	if cartridge.ReadRAM(0xA000) != 0x12 {
+		t.Error("RAM bank switched in mode 0")
+	}
+	cartridge.WriteROM(0x6000, 0x01)
+	cartridge.WriteRAM(0xA000, 0x34)
+	cartridge.WriteROM(0x6000, 0x00)
+	if cartridge.ReadRAM(0xA000) != 0x12 {
+		t.Error("Write to RAM bank 2 changed bank 0")
+	}
+	cartridge.WriteROM(0x6000, 0x01)
+	if cartridge.ReadRAM(0xA000) != 0x34 {
+		t.Error("RAM bank 2 lost its value")
+	}
+
+	cartridge.WriteROM(0x0000, 0x00)
+	if cartridge.ReadRAM(0xA000) != 0xFF {
+		t.Error("RAM is readable after being disabled")
+	}
+}
+
+func TestMBC1M(t *testing.T) {
+	rom := newTestROM(64, 0x01, 0x00)
+	for i := 0x0104; i < 0x0134; i++ {
+		rom[i] = uint8(i)
+		rom[0x10*ROMBANKSIZE+i] = uint8(i)
+	}
+	cartridge, err := NewCartridge(rom)
+	check(err)
+
+	if !cartridge.(*MBC1).multicart {
+		t.Fatal("MBC1M cartridge not detected")
+	}
+
+	// bank2 selects the game, and only 4 bits of the ROM bank register are connected.
+	cartridge.WriteROM(0x4000, 0x01)
+	cartridge.WriteROM(0x2000, 0x13)
+	if bank := cartridge.ReadROM(0x4000); bank != 0x13 {
+		t.Errorf("MBC1M mapped bank %X instead of 13", bank)
+	}
+	cartridge.WriteROM(0x6000, 0x01)
+	if bank := cartridge.ReadROM(0x0000); bank != 0x10 {
+		t.Errorf("MBC1M mapped bank %X at 0x0000 instead of 10", bank)
+	}
+}
+
+// fakeClock is a Clock which only moves when a test advances it.
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+// newTestMBC3 returns an MBC3 cartridge with RTC, RAM and timer enabled, and a fake clock.
+func newTestMBC3() (*MBC3, *fakeClock) {
+	cartridge, err := NewCartridge(newTestROM(128, 0x10, 0x03))
+	check(err)
+	mbc3 := cartridge.(*MBC3)
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	mbc3.rtc.clock = clock
+	mbc3.WriteROM(0x0000, 0x0A)
+	return mbc3, clock
+}
+
+// readRTC latches the clock and returns the value of an RTC register.
+func readRTC(mbc3 *MBC3, register uint8) uint8 {
+	mbc3.WriteROM(0x6000, 0x00)
+	mbc3.WriteROM(0x6000, 0x01)
+	mbc3.WriteROM(0x4000, register)
+	return mbc3.ReadRAM(0xA000)
+}
+
+func TestMBC3Banking(t *testing.T) {
+	mbc3, _ := newTestMBC3()
+
+	mbc3.WriteROM(0x2000, 0x00)
+	if bank := mbc3.ReadROM(0x4000); bank != 1 {
+		t.Errorf("ROM bank 0 mapped bank %d instead of 1", bank)
+	}
+	mbc3.WriteROM(0x2000, 0x7F)
+	if bank := mbc3.ReadROM(0x4000); bank != 127 {
+		t.Errorf("ROM bank 127 mapped bank %d", bank)
+	}
+
+	mbc3.WriteROM(0x4000, 0x03)
+	mbc3.WriteRAM(0xA000, 0x12)
+	mbc3.WriteROM(0x4000, 0x00)
+	if mbc3.ReadRAM(0xA000) == 0x12 {
+		t.Error("Write to RAM bank 3 changed bank 0")
+	}
+	mbc3.WriteROM(0x4000, 0x03)
+	if mbc3.ReadRAM(0xA000) != 0x12 {
+		t.Error("RAM bank 3 lost its value")
+	}
+}
+
+func TestMBC3RTC(t *testing.T) {
+	mbc3, clock := newTestMBC3()
+	readRTC(mbc3, RTCSECONDS)
+
+	clock.now = clock.now.Add(90*time.Minute + 5*time.Second)
+	if s := mbc3.ReadRAM(0xA000); s != 0 {
+		t.Errorf("RTC seconds changed to %d without latching", s)
+	}
+
+	tables := []struct {
+		register uint8
+		value    uint8
+	}{
+		{RTCSECONDS, 5},
+		{RTCMINUTES, 30},
+		{RTCHOURS, 1},
+		{RTCDAYLOW, 0},
+		{RTCDAYHIGH, 0},
+	}
+	for _, table := range tables {
+		if value := readRTC(mbc3, table.register); value != table.value {
+			t.Errorf("RTC register %X = %d, should be %d", table.register, value, table.value)
+		}
+	}
+
+	// Day counter overflow sets the carry bit and wraps to 0
+	clock.now = clock.now.Add(511 * 24 * time.Hour)
+	if dl, dh := readRTC(mbc3, RTCDAYLOW), readRTC(mbc3, RTCDAYHIGH); dl != 0xFF || dh != 0x01 {
+		t.Errorf("After 511 days DL = %X, DH = %X, should be FF and 01", dl, dh)
+	}
+	clock.now = clock.now.Add(24 * time.Hour)
+	if dl, dh := readRTC(mbc3, RTCDAYLOW), readRTC(mbc3, RTCDAYHIGH); dl != 0x00 || dh != 0x80 {
+		t.Errorf("After 512 days DL = %X, DH = %X, should be 00 and 80", dl, dh)
+	}
+}
+
+func TestMBC3RTCHalt(t *testing.T) {
+	mbc3, clock := newTestMBC3()
+	readRTC(mbc3, RTCSECONDS)
+
+	mbc3.WriteROM(0x4000, RTCDAYHIGH)
+	mbc3.WriteRAM(0xA000, 0x40)
+	clock.now = clock.now.Add(time.Hour)
+	if h := readRTC(mbc3, RTCHOURS); h != 0 {
+		t.Errorf("Halted RTC advanced to %d hours", h)
+	}
+
+	// Writing registers while halted sets the time, which counts from there once resumed
+	mbc3.WriteROM(0x4000, RTCMINUTES)
+	mbc3.WriteRAM(0xA000, 59)
+	mbc3.WriteROM(0x4000, RTCDAYHIGH)
+	mbc3.WriteRAM(0xA000, 0x00)
+	clock.now = clock.now.Add(time.Minute + 500*time.Millisecond)
+	if h, m := readRTC(mbc3, RTCHOURS), readRTC(mbc3, RTCMINUTES); h != 1 || m != 0 {
+		t.Errorf("Resumed RTC is at %d:%d instead of 1:00", h, m)
+	}
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	if s := readRTC(mbc3, RTCSECONDS); s != 1 {
+		t.Errorf("RTC lost the sub-second remainder, seconds = %d instead of 1", s)
+	}
+}
+
+func TestMBC5(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(512, 0x1B, 0x04))
+	check(err)
+
+	tables := []struct {
+		address uint16
+		value   uint8
+		bank    int
+	}{
+		{0x2000, 0x00, 0}, // Bank 0 can be mapped
+		{0x2000, 0xFF, 255},
+		{0x3000, 0x01, 511}, // Bit 8
+		{0x2FFF, 0x02, 258},
+		{0x3FFF, 0x00, 2},
+	}
+	for _, table := range tables {
+		cartridge.WriteROM(table.address, table.value)
+		// The bank marker is only 8 bits, so compare the low byte
+		if bank := cartridge.ReadROM(0x4000); bank != uint8(table.bank) {
+			t.Errorf("After writing %X to $%X, mapped bank %d instead of %d", table.value, table.address, bank, table.bank)
+		}
+	}
+
+	cartridge.WriteROM(0x0000, 0x0A)
+	cartridge.WriteROM(0x4000, 0x0F)
+	cartridge.WriteRAM(0xBFFF, 0x12)
+	cartridge.WriteROM(0x4000, 0x00)
+	if cartridge.ReadRAM(0xBFFF) == 0x12 {
+		t.Error("Write to RAM bank 15 changed bank 0")
+	}
+	cartridge.WriteROM(0x4000, 0x0F)
+	if cartridge.ReadRAM(0xBFFF) != 0x12 {
+		t.Error("RAM bank 15 lost its value")
+	}
+}
+
+func TestMBC5Rumble(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(4, 0x1E, 0x03))
+	check(err)
+	mbc5 := cartridge.(*MBC5)
+
+	events := []bool{}
+	mbc5.SetRumbleHandler(func(on bool) { events = append(events, on) })
+
+	mbc5.WriteROM(0x0000, 0x0A)
+	mbc5.WriteROM(0x4000, 0x09)
+	mbc5.WriteROM(0x4000, 0x09)
+	mbc5.WriteRAM(0xA000, 0x34)
+	mbc5.WriteROM(0x4000, 0x01)
+
+	if len(events) != 2 || !events[0] || events[1] {
+		t.Errorf("Rumble events were %v instead of [true false]", events)
+	}
+	if mbc5.ReadRAM(0xA000) != 0x34 {
+		t.Error("Rumble bit changed the RAM bank")
+	}
+}
+
+func TestMBC2(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(16, 0x06, 0x00))
+	check(err)
+
+	// Address bit 8 set selects the ROM bank register
+	cartridge.WriteROM(0x2100, 0x0F)
+	if bank := cartridge.ReadROM(0x4000); bank != 15 {
+		t.Errorf("ROM bank 15 mapped bank %d", bank)
+	}
+	cartridge.WriteROM(0x0100, 0x00)
+	if bank := cartridge.ReadROM(0x4000); bank != 1 {
+		t.Errorf("ROM bank 0 mapped bank %d instead of 1", bank)
+	}
+
+	// Address bit 8 clear selects the RAM enable register
+	cartridge.WriteROM(0x3000, 0x0A)
+	if cartridge.ReadROM(0x4000) != 1 {
+		t.Error("RAM enable write changed the ROM bank")
+	}
+	cartridge.WriteRAM(0xA000, 0xAB)
+	if value := cartridge.ReadRAM(0xA000); value != 0xFB {
+		t.Errorf("MBC2 RAM read %X instead of FB", value)
+	}
+	if value := cartridge.ReadRAM(0xA200); value != 0xFB {
+		t.Errorf("MBC2 RAM does not repeat every 512 bytes, $A200 read %X", value)
+	}
+
+	cartridge.WriteROM(0x0000, 0x00)
+	if cartridge.ReadRAM(0xA000) != 0xFF {
+		t.Error("MBC2 RAM is readable after being disabled")
+	}
+}
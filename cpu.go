@@ -1,8 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"unsafe"
 )
 
@@ -33,9 +34,20 @@ type CPU struct {
 	cbOpcodeMap map[uint8]func() string
 
 	bootloader [0x100]byte
-	cycles     uint64
+	// bootSkipped is set when the CPU starts in the post-boot state instead of running the bootloader
+	bootSkipped bool
+	cycles      uint64
+
+	ime      bool
+	imeDelay uint8
+	halted   bool
+	haltBug  bool
+	stopped  bool
 
 	breaking bool
+
+	// trace, if set, receives a line for each instruction executed
+	trace io.Writer
 }
 
 // Instruction is only used for printing instruction information
@@ -56,15 +68,8 @@ type Register struct {
 	word uint16
 }
 
-// Reset links a new MMU to the CPU, clears the registers, sets up the opcode maps, and loads in the bootloader data from a file.
+// Reset links a new MMU to the CPU, clears the registers, and sets up the opcode maps.
 func (c *CPU) Reset(mmu *MMU) {
-	dat, err := ioutil.ReadFile("./data/DMG_ROM.bin")
-	check(err)
-
-	for i, v := range dat {
-		c.bootloader[i] = v
-	}
-
 	// These register setups enable the C-type union stuff.
 	// TODO: Make sure this still works on other systems.
 	c.AF.word = 0x0
@@ -94,16 +99,9 @@ func (c *CPU) Reset(mmu *MMU) {
 // SetupOpcodeMap fills in the opcodeMap and cbOpcodeMap
 func (c *CPU) SetupOpcodeMap() {
 
-	// INC/DEC
 	c.opcodeMap = make(map[uint8]func() string)
-	c.opcodeMap[0x3C] = func() string {
-		c.Inc8(c.AF.hi)
-		return "INC A"
-	}
-	c.opcodeMap[0x3D] = func() string {
-		c.Dec8(c.AF.hi)
-		return "DEC A"
-	}
+
+	// INC/DEC
 	c.opcodeMap[0x04] = func() string {
 		c.Inc8(c.BC.hi)
 		return "INC B"
@@ -149,9 +147,31 @@ func (c *CPU) SetupOpcodeMap() {
 		return "INC L"
 	}
 	c.opcodeMap[0x2D] = func() string {
-		c.Inc8(c.HL.lo)
+		c.Dec8(c.HL.lo)
 		return "DEC L"
 	}
+	c.opcodeMap[0x34] = func() string {
+		byte := c.mmu.ReadByte(c.HL.word)
+		c.Inc8(&byte)
+		c.mmu.WriteByte(c.HL.word, byte)
+		c.cycles += 8
+		return "INC (HL)"
+	}
+	c.opcodeMap[0x35] = func() string {
+		byte := c.mmu.ReadByte(c.HL.word)
+		c.Dec8(&byte)
+		c.mmu.WriteByte(c.HL.word, byte)
+		c.cycles += 8
+		return "DEC (HL)"
+	}
+	c.opcodeMap[0x3C] = func() string {
+		c.Inc8(c.AF.hi)
+		return "INC A"
+	}
+	c.opcodeMap[0x3D] = func() string {
+		c.Dec8(c.AF.hi)
+		return "DEC A"
+	}
 	c.opcodeMap[0x03] = func() string {
 		c.Inc16(&c.BC.word)
 		return "INC BC"
@@ -176,12 +196,16 @@ func (c *CPU) SetupOpcodeMap() {
 		c.Dec16(&c.HL.word)
 		return "DEC HL"
 	}
+	c.opcodeMap[0x33] = func() string {
+		c.Inc16(&c.SP.word)
+		return "INC SP"
+	}
+	c.opcodeMap[0x3B] = func() string {
+		c.Dec16(&c.SP.word)
+		return "DEC SP"
+	}
 
 	// LD R,d8
-	c.opcodeMap[0x3E] = func() string {
-		c.LdByte(c.AF.hi)
-		return "LD A,d8"
-	}
 	c.opcodeMap[0x06] = func() string {
 		c.LdByte(c.BC.hi)
 		return "LD B,d8"
@@ -206,40 +230,18 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdByte(c.HL.lo)
 		return "LD L,d8"
 	}
-
-	// LD R,R
-	c.opcodeMap[0x7F] = func() string {
-		c.LdReg8(c.AF.hi, c.AF.hi)
-		return "LD A,A"
-	}
-	c.opcodeMap[0x78] = func() string {
-		c.LdReg8(c.AF.hi, c.BC.hi)
-		return "LD A,B"
-	}
-	c.opcodeMap[0x79] = func() string {
-		c.LdReg8(c.AF.hi, c.BC.lo)
-		return "LD A,C"
-	}
-	c.opcodeMap[0x7A] = func() string {
-		c.LdReg8(c.AF.hi, c.DE.hi)
-		return "LD A,D"
-	}
-	c.opcodeMap[0x7B] = func() string {
-		c.LdReg8(c.AF.hi, c.DE.lo)
-		return "LD A,E"
-	}
-	c.opcodeMap[0x7C] = func() string {
-		c.LdReg8(c.AF.hi, c.HL.hi)
-		return "LD A,H"
-	}
-	c.opcodeMap[0x7D] = func() string {
-		c.LdReg8(c.AF.hi, c.HL.lo)
-		return "LD A,L"
+	c.opcodeMap[0x36] = func() string {
+		c.mmu.WriteByte(c.HL.word, c.mmu.ReadByte(c.PC.word+1))
+		c.PC.word += 2
+		c.cycles += 12
+		return "LD (HL),d8"
 	}
-	c.opcodeMap[0x47] = func() string {
-		c.LdReg8(c.BC.hi, c.AF.hi)
-		return "LD B,A"
+	c.opcodeMap[0x3E] = func() string {
+		c.LdByte(c.AF.hi)
+		return "LD A,d8"
 	}
+
+	// LD R,R
 	c.opcodeMap[0x40] = func() string {
 		c.LdReg8(c.BC.hi, c.BC.hi)
 		return "LD B,B"
@@ -264,9 +266,13 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.BC.hi, c.HL.lo)
 		return "LD B,L"
 	}
-	c.opcodeMap[0x4F] = func() string {
-		c.LdReg8(c.BC.lo, c.AF.hi)
-		return "LD C,A"
+	c.opcodeMap[0x46] = func() string {
+		c.LdReg8Adr(c.BC.hi, c.HL.word)
+		return "LD B,(HL)"
+	}
+	c.opcodeMap[0x47] = func() string {
+		c.LdReg8(c.BC.hi, c.AF.hi)
+		return "LD B,A"
 	}
 	c.opcodeMap[0x48] = func() string {
 		c.LdReg8(c.BC.lo, c.BC.hi)
@@ -292,9 +298,13 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.BC.lo, c.HL.lo)
 		return "LD C,L"
 	}
-	c.opcodeMap[0x57] = func() string {
-		c.LdReg8(c.DE.hi, c.AF.hi)
-		return "LD D,A"
+	c.opcodeMap[0x4E] = func() string {
+		c.LdReg8Adr(c.BC.lo, c.HL.word)
+		return "LD C,(HL)"
+	}
+	c.opcodeMap[0x4F] = func() string {
+		c.LdReg8(c.BC.lo, c.AF.hi)
+		return "LD C,A"
 	}
 	c.opcodeMap[0x50] = func() string {
 		c.LdReg8(c.DE.hi, c.BC.hi)
@@ -320,9 +330,13 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.DE.hi, c.HL.lo)
 		return "LD D,L"
 	}
-	c.opcodeMap[0x5F] = func() string {
-		c.LdReg8(c.DE.lo, c.AF.hi)
-		return "LD E,A"
+	c.opcodeMap[0x56] = func() string {
+		c.LdReg8Adr(c.DE.hi, c.HL.word)
+		return "LD D,(HL)"
+	}
+	c.opcodeMap[0x57] = func() string {
+		c.LdReg8(c.DE.hi, c.AF.hi)
+		return "LD D,A"
 	}
 	c.opcodeMap[0x58] = func() string {
 		c.LdReg8(c.DE.lo, c.BC.hi)
@@ -348,9 +362,13 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.DE.lo, c.HL.lo)
 		return "LD E,L"
 	}
-	c.opcodeMap[0x67] = func() string {
-		c.LdReg8(c.HL.hi, c.AF.hi)
-		return "LD H,A"
+	c.opcodeMap[0x5E] = func() string {
+		c.LdReg8Adr(c.DE.lo, c.HL.word)
+		return "LD E,(HL)"
+	}
+	c.opcodeMap[0x5F] = func() string {
+		c.LdReg8(c.DE.lo, c.AF.hi)
+		return "LD E,A"
 	}
 	c.opcodeMap[0x60] = func() string {
 		c.LdReg8(c.HL.hi, c.BC.hi)
@@ -376,9 +394,13 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.HL.hi, c.HL.lo)
 		return "LD H,L"
 	}
-	c.opcodeMap[0x6F] = func() string {
-		c.LdReg8(c.HL.lo, c.AF.hi)
-		return "LD L,A"
+	c.opcodeMap[0x66] = func() string {
+		c.LdReg8Adr(c.HL.hi, c.HL.word)
+		return "LD H,(HL)"
+	}
+	c.opcodeMap[0x67] = func() string {
+		c.LdReg8(c.HL.hi, c.AF.hi)
+		return "LD H,A"
 	}
 	c.opcodeMap[0x68] = func() string {
 		c.LdReg8(c.HL.lo, c.BC.hi)
@@ -404,8 +426,76 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8(c.HL.lo, c.HL.lo)
 		return "LD L,L"
 	}
+	c.opcodeMap[0x6E] = func() string {
+		c.LdReg8Adr(c.HL.lo, c.HL.word)
+		return "LD L,(HL)"
+	}
+	c.opcodeMap[0x6F] = func() string {
+		c.LdReg8(c.HL.lo, c.AF.hi)
+		return "LD L,A"
+	}
+	c.opcodeMap[0x70] = func() string {
+		c.LdAdrReg8(c.HL.word, c.BC.hi)
+		return "LD (HL),B"
+	}
+	c.opcodeMap[0x71] = func() string {
+		c.LdAdrReg8(c.HL.word, c.BC.lo)
+		return "LD (HL),C"
+	}
+	c.opcodeMap[0x72] = func() string {
+		c.LdAdrReg8(c.HL.word, c.DE.hi)
+		return "LD (HL),D"
+	}
+	c.opcodeMap[0x73] = func() string {
+		c.LdAdrReg8(c.HL.word, c.DE.lo)
+		return "LD (HL),E"
+	}
+	c.opcodeMap[0x74] = func() string {
+		c.LdAdrReg8(c.HL.word, c.HL.hi)
+		return "LD (HL),H"
+	}
+	c.opcodeMap[0x75] = func() string {
+		c.LdAdrReg8(c.HL.word, c.HL.lo)
+		return "LD (HL),L"
+	}
+	c.opcodeMap[0x77] = func() string {
+		c.LdAdrReg8(c.HL.word, c.AF.hi)
+		return "LD (HL),A"
+	}
+	c.opcodeMap[0x78] = func() string {
+		c.LdReg8(c.AF.hi, c.BC.hi)
+		return "LD A,B"
+	}
+	c.opcodeMap[0x79] = func() string {
+		c.LdReg8(c.AF.hi, c.BC.lo)
+		return "LD A,C"
+	}
+	c.opcodeMap[0x7A] = func() string {
+		c.LdReg8(c.AF.hi, c.DE.hi)
+		return "LD A,D"
+	}
+	c.opcodeMap[0x7B] = func() string {
+		c.LdReg8(c.AF.hi, c.DE.lo)
+		return "LD A,E"
+	}
+	c.opcodeMap[0x7C] = func() string {
+		c.LdReg8(c.AF.hi, c.HL.hi)
+		return "LD A,H"
+	}
+	c.opcodeMap[0x7D] = func() string {
+		c.LdReg8(c.AF.hi, c.HL.lo)
+		return "LD A,L"
+	}
+	c.opcodeMap[0x7E] = func() string {
+		c.LdReg8Adr(c.AF.hi, c.HL.word)
+		return "LD A,(HL)"
+	}
+	c.opcodeMap[0x7F] = func() string {
+		c.LdReg8(c.AF.hi, c.AF.hi)
+		return "LD A,A"
+	}
 
-	// LD R,a16
+	// LD A,(RR) and LD (RR),A
 	c.opcodeMap[0x0A] = func() string {
 		c.LdReg8Adr(c.AF.hi, c.BC.word)
 		return "LD A,(BC)"
@@ -414,34 +504,71 @@ func (c *CPU) SetupOpcodeMap() {
 		c.LdReg8Adr(c.AF.hi, c.DE.word)
 		return "LD A,(DE)"
 	}
-	c.opcodeMap[0x7E] = func() string {
-		c.LdReg8Adr(c.AF.hi, c.HL.word)
-		return "LD A,(HL)"
-	}
 	c.opcodeMap[0x2A] = func() string {
+		// These are faster than the sum of their parts, so can't just call LdReg8Adr and Inc16
 		c.LdReg8Adr(c.AF.hi, c.HL.word)
-		c.Inc16(&c.HL.word)
+		c.HL.word++
 		return "LD A,(HL+)"
 	}
 	c.opcodeMap[0x3A] = func() string {
 		c.LdReg8Adr(c.AF.hi, c.HL.word)
-		c.Dec16(&c.HL.word)
+		c.HL.word--
 		return "LD A,(HL-)"
 	}
+	c.opcodeMap[0x02] = func() string {
+		c.LdAdrA(c.BC.word)
+		return "LD (BC),A"
+	}
+	c.opcodeMap[0x12] = func() string {
+		c.LdAdrA(c.DE.word)
+		return "LD (DE),A"
+	}
+	c.opcodeMap[0x22] = func() string {
+		c.LdAdrA(c.HL.word)
+		c.HL.word++
+		return "LD (HL+),A"
+	}
+	c.opcodeMap[0x32] = func() string {
+		c.LdAdrA(c.HL.word)
+		c.HL.word--
+		return "LD (HL-),A"
+	}
+
+	// LDH and LD with 16-bit addresses
+	c.opcodeMap[0xE0] = func() string {
+		c.mmu.WriteByte(0xFF00|uint16(c.mmu.ReadByte(c.PC.word+1)), *c.AF.hi)
+		c.PC.word += 2
+		c.cycles += 12
+		return "LDH (a8),A"
+	}
 	c.opcodeMap[0xF0] = func() string {
 		*c.AF.hi = c.mmu.ReadByte(0xFF00 | uint16(c.mmu.ReadByte(c.PC.word+1)))
 		c.PC.word += 2
 		c.cycles += 12
-		return "LDH A,a8"
+		return "LDH A,(a8)"
 	}
-	c.opcodeMap[0x2F] = func() string {
-		*c.AF.hi = c.mmu.ReadByte(0xFF00 | uint16(*c.BC.lo))
-		c.PC.word += 2
-		c.cycles += 8
-		return "LDH A,(C)"
+	c.opcodeMap[0xE2] = func() string {
+		c.LdAdrA(0xFF00 | uint16(*c.BC.lo))
+		return "LD (C),A"
+	}
+	c.opcodeMap[0xF2] = func() string {
+		c.LdReg8Adr(c.AF.hi, 0xFF00|uint16(*c.BC.lo))
+		return "LD A,(C)"
+	}
+	c.opcodeMap[0xEA] = func() string {
+		c.mmu.WriteByte(c.mmu.ReadWord(c.PC.word+1), *c.AF.hi)
+		c.PC.word += 3
+		c.cycles += 16
+		return "LD (a16),A"
+	}
+	c.opcodeMap[0xFA] = func() string {
+		*c.AF.hi = c.mmu.ReadByte(c.mmu.ReadWord(c.PC.word + 1))
+		c.PC.word += 3
+		c.cycles += 16
+		return "LD A,(a16)"
 	}
 
-	// LD RR,d16
+	// LD RR,d16 and other 16-bit loads
 	c.opcodeMap[0x01] = func() string {
 		c.LdWord(&c.BC.word)
 		return "LD BC,d16"
@@ -455,60 +582,26 @@ func (c *CPU) SetupOpcodeMap() {
 		return "LD HL,d16"
 	}
 	c.opcodeMap[0x31] = func() string {
-		c.SP.word = c.mmu.ReadWord(c.PC.word + 1)
-		c.PC.word += 3
-		c.cycles += 12
+		c.LdWord(&c.SP.word)
 		return "LD SP,d16"
 	}
-
-	// LD address,R
-	c.opcodeMap[0xE2] = func() string {
-		c.mmu.WriteByte(0xFF00|uint16(*c.BC.lo), *c.AF.hi)
-		c.PC.word++ // Opcode table says 2 but that seems wrong
-		c.cycles += 8
-		return "LD (C),A"
-	}
-	c.opcodeMap[0x02] = func() string {
-		c.LdAdrA(c.BC.word)
-		return "LD (BC),A"
-	}
-	c.opcodeMap[0x12] = func() string {
-		c.LdAdrA(c.DE.word)
-		return "LD (DE),A"
-	}
-	c.opcodeMap[0x77] = func() string {
-		c.LdAdrA(c.HL.word)
-		return "LD (HL),A"
-	}
-	c.opcodeMap[0x32] = func() string {
-		// These are faster than the sum of their parts, so can't just call LdAddrA
-		c.mmu.WriteByte(c.HL.word, *c.AF.hi)
-		c.Dec16(&c.HL.word)
-		return "LD (HL-),A"
-	}
-	c.opcodeMap[0x22] = func() string {
-		// These are faster than the sum of their parts, so can't just call LdAddrA
-		c.mmu.WriteByte(c.HL.word, *c.AF.hi)
-		c.Inc16(&c.HL.word)
-		return "LD (HL+),A"
+	c.opcodeMap[0x08] = func() string {
+		c.mmu.WriteWord(c.mmu.ReadWord(c.PC.word+1), c.SP.word)
+		c.PC.word += 3
+		c.cycles += 20
+		return "LD (a16),SP"
 	}
-	c.opcodeMap[0x36] = func() string {
-		c.mmu.WriteByte(c.HL.word, c.mmu.ReadByte(c.PC.word+1))
-		c.PC.word += 2
-		c.cycles += 12
-		return "LD (HL),d8"
+	c.opcodeMap[0xF9] = func() string {
+		c.SP.word = c.HL.word
+		c.PC.word++
+		c.cycles += 8
+		return "LD SP,HL"
 	}
-	c.opcodeMap[0xE0] = func() string {
-		c.mmu.WriteByte(0xFF00|uint16(c.mmu.ReadByte(c.PC.word+1)), *c.AF.hi)
+	c.opcodeMap[0xF8] = func() string {
+		c.HL.word = c.SPPlusOffset()
 		c.PC.word += 2
 		c.cycles += 12
-		return "LDH (a8),A"
-	}
-	c.opcodeMap[0xEA] = func() string {
-		c.mmu.WriteByte(c.mmu.ReadWord(c.PC.word+1), *c.AF.hi)
-		c.PC.word += 3
-		c.cycles += 16
-		return "LD a16,A"
+		return "LD HL,SP+r8"
 	}
 
 	// Jump
@@ -524,61 +617,180 @@ func (c *CPU) SetupOpcodeMap() {
 		c.JRCond(c.GetZeroFlag())
 		return "JR Z,r8"
 	}
+	c.opcodeMap[0x30] = func() string {
+		c.JRCond(!c.GetCarryFlag())
+		return "JR NC,r8"
+	}
+	c.opcodeMap[0x38] = func() string {
+		c.JRCond(c.GetCarryFlag())
+		return "JR C,r8"
+	}
 	c.opcodeMap[0xC3] = func() string {
-		c.PC.word = c.mmu.ReadWord(c.PC.word + 1)
-		c.cycles += 16
+		c.JPCond(true)
 		return "JP a16"
 	}
-
-	// Stack ops
-	c.opcodeMap[0xC5] = func() string {
-		c.PushWord(c.BC.word)
-		return "PUSH BC"
+	c.opcodeMap[0xC2] = func() string {
+		c.JPCond(!c.GetZeroFlag())
+		return "JP NZ,a16"
 	}
-	c.opcodeMap[0xC1] = func() string {
-		c.PopWord(&c.BC.word)
-		return "POP BC"
+	c.opcodeMap[0xCA] = func() string {
+		c.JPCond(c.GetZeroFlag())
+		return "JP Z,a16"
+	}
+	c.opcodeMap[0xD2] = func() string {
+		c.JPCond(!c.GetCarryFlag())
+		return "JP NC,a16"
+	}
+	c.opcodeMap[0xDA] = func() string {
+		c.JPCond(c.GetCarryFlag())
+		return "JP C,a16"
+	}
+	c.opcodeMap[0xE9] = func() string {
+		c.PC.word = c.HL.word
+		c.cycles += 4
+		return "JP (HL)"
+	}
+
+	// Calls and returns
+	c.opcodeMap[0xCD] = func() string {
+		c.CallCond(true)
+		return "CALL a16"
+	}
+	c.opcodeMap[0xC4] = func() string {
+		c.CallCond(!c.GetZeroFlag())
+		return "CALL NZ,a16"
+	}
+	c.opcodeMap[0xCC] = func() string {
+		c.CallCond(c.GetZeroFlag())
+		return "CALL Z,a16"
+	}
+	c.opcodeMap[0xD4] = func() string {
+		c.CallCond(!c.GetCarryFlag())
+		return "CALL NC,a16"
+	}
+	c.opcodeMap[0xDC] = func() string {
+		c.CallCond(c.GetCarryFlag())
+		return "CALL C,a16"
 	}
 	c.opcodeMap[0xC9] = func() string {
-		c.SP.word += 2
-		c.PC.word = c.mmu.ReadWord(c.SP.word)
+		c.PC.word = c.PopStack()
 		c.cycles += 16
 		return "RET"
 	}
-	c.opcodeMap[0xCD] = func() string {
-		c.mmu.WriteWord(c.SP.word, c.PC.word+3)
-		c.SP.word -= 2
-		c.PC.word = c.mmu.ReadWord(c.PC.word + 1)
-		c.cycles += 24
-		return "CALL a16"
+	c.opcodeMap[0xC0] = func() string {
+		c.RetCond(!c.GetZeroFlag())
+		return "RET NZ"
 	}
-	c.opcodeMap[0xEF] = func() string {
-		c.mmu.WriteWord(c.SP.word, c.PC.word+1)
-		c.SP.word -= 2
-		c.PC.word = 0x28
+	c.opcodeMap[0xC8] = func() string {
+		c.RetCond(c.GetZeroFlag())
+		return "RET Z"
+	}
+	c.opcodeMap[0xD0] = func() string {
+		c.RetCond(!c.GetCarryFlag())
+		return "RET NC"
+	}
+	c.opcodeMap[0xD8] = func() string {
+		c.RetCond(c.GetCarryFlag())
+		return "RET C"
+	}
+	c.opcodeMap[0xD9] = func() string {
+		c.PC.word = c.PopStack()
+		c.ime = true
 		c.cycles += 16
+		return "RETI"
+	}
+	c.opcodeMap[0xC7] = func() string {
+		c.Rst(0x00)
+		return "RST $00"
+	}
+	c.opcodeMap[0xCF] = func() string {
+		c.Rst(0x08)
+		return "RST $08"
+	}
+	c.opcodeMap[0xD7] = func() string {
+		c.Rst(0x10)
+		return "RST $10"
+	}
+	c.opcodeMap[0xDF] = func() string {
+		c.Rst(0x18)
+		return "RST $18"
+	}
+	c.opcodeMap[0xE7] = func() string {
+		c.Rst(0x20)
+		return "RST $20"
+	}
+	c.opcodeMap[0xEF] = func() string {
+		c.Rst(0x28)
 		return "RST $28"
 	}
+	c.opcodeMap[0xF7] = func() string {
+		c.Rst(0x30)
+		return "RST $30"
+	}
+	c.opcodeMap[0xFF] = func() string {
+		c.Rst(0x38)
+		return "RST $38"
+	}
+
+	// Stack ops
+	c.opcodeMap[0xC5] = func() string {
+		c.PushWord(c.BC.word)
+		return "PUSH BC"
+	}
+	c.opcodeMap[0xD5] = func() string {
+		c.PushWord(c.DE.word)
+		return "PUSH DE"
+	}
+	c.opcodeMap[0xE5] = func() string {
+		c.PushWord(c.HL.word)
+		return "PUSH HL"
+	}
+	c.opcodeMap[0xF5] = func() string {
+		c.PushWord(c.AF.word)
+		return "PUSH AF"
+	}
+	c.opcodeMap[0xC1] = func() string {
+		c.PopWord(&c.BC.word)
+		return "POP BC"
+	}
+	c.opcodeMap[0xD1] = func() string {
+		c.PopWord(&c.DE.word)
+		return "POP DE"
+	}
+	c.opcodeMap[0xE1] = func() string {
+		c.PopWord(&c.HL.word)
+		return "POP HL"
+	}
+	c.opcodeMap[0xF1] = func() string {
+		c.PopWord(&c.AF.word)
+		// The lower 4 bits of F are always 0
+		*c.AF.lo &= 0xF0
+		return "POP AF"
+	}
 
-	// Arithmetic
+	// Rotates on A. Unlike the CB versions these always reset the zero flag.
 	c.opcodeMap[0x07] = func() string {
 		c.RotateLeftCarry(c.AF.hi)
+		c.UnsetZeroFlag()
 		return "RLCA"
 	}
 	c.opcodeMap[0x17] = func() string {
 		c.RotateLeft(c.AF.hi)
+		c.UnsetZeroFlag()
 		return "RLA"
 	}
-
-	c.opcodeMap[0x1F] = func() string {
-		c.RotateRight(c.AF.hi)
-		return "RRA"
-	}
 	c.opcodeMap[0x0F] = func() string {
 		c.RotateRightCarry(c.AF.hi)
+		c.UnsetZeroFlag()
 		return "RRCA"
 	}
+	c.opcodeMap[0x1F] = func() string {
+		c.RotateRight(c.AF.hi)
+		c.UnsetZeroFlag()
+		return "RRA"
+	}
 
+	// 16-bit arithmetic
 	c.opcodeMap[0x09] = func() string {
 		c.AddReg16(&c.BC.word)
 		return "ADD HL,BC"
@@ -595,53 +807,81 @@ func (c *CPU) SetupOpcodeMap() {
 		c.AddReg16(&c.SP.word)
 		return "ADD HL,SP"
 	}
+	c.opcodeMap[0xE8] = func() string {
+		c.SP.word = c.SPPlusOffset()
+		c.PC.word += 2
+		c.cycles += 16
+		return "ADD SP,r8"
+	}
+
+	// 8-bit arithmetic and logic
 	c.opcodeMap[0x80] = func() string {
 		c.AddReg8(c.BC.hi)
-		return "ADD B"
+		return "ADD A,B"
 	}
 	c.opcodeMap[0x81] = func() string {
 		c.AddReg8(c.BC.lo)
-		return "ADD C"
+		return "ADD A,C"
 	}
 	c.opcodeMap[0x82] = func() string {
 		c.AddReg8(c.DE.hi)
-		return "ADD D"
+		return "ADD A,D"
 	}
 	c.opcodeMap[0x83] = func() string {
 		c.AddReg8(c.DE.lo)
-		return "ADD E"
+		return "ADD A,E"
 	}
 	c.opcodeMap[0x84] = func() string {
 		c.AddReg8(c.HL.hi)
-		return "ADD H"
+		return "ADD A,H"
 	}
 	c.opcodeMap[0x85] = func() string {
 		c.AddReg8(c.HL.lo)
-		return "ADD L"
+		return "ADD A,L"
 	}
 	c.opcodeMap[0x86] = func() string {
-		c.UnsetSubtractionFlag()
-		c.UnsetCarryFlag()
-		c.UnsetHalfCarryFlag()
-		c.UnsetZeroFlag()
-
-		byte := c.mmu.ReadByte(c.HL.word)
-		*c.AF.hi += byte
-
-		if *c.AF.hi < byte {
-			c.SetCarryFlag()
-			c.SetHalfCarryFlag()
-		}
-		if *c.AF.hi == 0 {
-			c.SetZeroFlag()
-		}
+		c.AddByte(c.mmu.ReadByte(c.HL.word), false)
 		c.PC.word++
 		c.cycles += 8
-		return "ADD (HL)"
+		return "ADD A,(HL)"
 	}
 	c.opcodeMap[0x87] = func() string {
 		c.AddReg8(c.AF.hi)
-		return "ADD A"
+		return "ADD A,A"
+	}
+	c.opcodeMap[0x88] = func() string {
+		c.AdcReg8(c.BC.hi)
+		return "ADC A,B"
+	}
+	c.opcodeMap[0x89] = func() string {
+		c.AdcReg8(c.BC.lo)
+		return "ADC A,C"
+	}
+	c.opcodeMap[0x8A] = func() string {
+		c.AdcReg8(c.DE.hi)
+		return "ADC A,D"
+	}
+	c.opcodeMap[0x8B] = func() string {
+		c.AdcReg8(c.DE.lo)
+		return "ADC A,E"
+	}
+	c.opcodeMap[0x8C] = func() string {
+		c.AdcReg8(c.HL.hi)
+		return "ADC A,H"
+	}
+	c.opcodeMap[0x8D] = func() string {
+		c.AdcReg8(c.HL.lo)
+		return "ADC A,L"
+	}
+	c.opcodeMap[0x8E] = func() string {
+		c.AddByte(c.mmu.ReadByte(c.HL.word), true)
+		c.PC.word++
+		c.cycles += 8
+		return "ADC A,(HL)"
+	}
+	c.opcodeMap[0x8F] = func() string {
+		c.AdcReg8(c.AF.hi)
+		return "ADC A,A"
 	}
 	c.opcodeMap[0x90] = func() string {
 		c.SubReg(c.BC.hi)
@@ -668,19 +908,7 @@ func (c *CPU) SetupOpcodeMap() {
 		return "SUB L"
 	}
 	c.opcodeMap[0x96] = func() string {
-		c.SetSubtractionFlag()
-		c.UnsetCarryFlag()
-		c.UnsetHalfCarryFlag()
-		c.UnsetZeroFlag()
-
-		byte := c.mmu.ReadByte(c.HL.word)
-		if *c.AF.hi < byte {
-			c.SetCarryFlag()
-			c.SetHalfCarryFlag()
-		} else if *c.AF.hi == byte {
-			c.SetZeroFlag()
-		}
-		*c.AF.hi -= byte
+		c.SubByte(c.mmu.ReadByte(c.HL.word), false)
 		c.PC.word++
 		c.cycles += 8
 		return "SUB (HL)"
@@ -689,49 +917,39 @@ func (c *CPU) SetupOpcodeMap() {
 		c.SubReg(c.AF.hi)
 		return "SUB A"
 	}
-	c.opcodeMap[0xA8] = func() string {
-		c.XorReg(c.BC.hi)
-		return "XOR B"
+	c.opcodeMap[0x98] = func() string {
+		c.SbcReg(c.BC.hi)
+		return "SBC A,B"
 	}
-	c.opcodeMap[0xA9] = func() string {
-		c.XorReg(c.BC.lo)
-		return "XOR C"
+	c.opcodeMap[0x99] = func() string {
+		c.SbcReg(c.BC.lo)
+		return "SBC A,C"
 	}
-	c.opcodeMap[0xAA] = func() string {
-		c.XorReg(c.DE.hi)
-		return "XOR D"
+	c.opcodeMap[0x9A] = func() string {
+		c.SbcReg(c.DE.hi)
+		return "SBC A,D"
 	}
-	c.opcodeMap[0xAB] = func() string {
-		c.XorReg(c.DE.lo)
-		return "XOR E"
+	c.opcodeMap[0x9B] = func() string {
+		c.SbcReg(c.DE.lo)
+		return "SBC A,E"
 	}
-	c.opcodeMap[0xAC] = func() string {
-		c.XorReg(c.HL.hi)
-		return "XOR H"
+	c.opcodeMap[0x9C] = func() string {
+		c.SbcReg(c.HL.hi)
+		return "SBC A,H"
 	}
-	c.opcodeMap[0xAD] = func() string {
-		c.XorReg(c.HL.lo)
-		return "XOR L"
+	c.opcodeMap[0x9D] = func() string {
+		c.SbcReg(c.HL.lo)
+		return "SBC A,L"
 	}
-	c.opcodeMap[0xAE] = func() string {
-		byte := c.mmu.ReadByte(c.HL.word)
-		*c.AF.hi ^= byte
-		if *c.AF.hi == 0 {
-			c.SetZeroFlag()
-		} else {
-			c.UnsetZeroFlag()
-		}
-		c.UnsetSubtractionFlag()
-		c.UnsetHalfCarryFlag()
-		c.UnsetCarryFlag()
+	c.opcodeMap[0x9E] = func() string {
+		c.SubByte(c.mmu.ReadByte(c.HL.word), true)
 		c.PC.word++
-		c.cycles += 4
-		c.cycles += 4
-		return "XOR (HL)"
+		c.cycles += 8
+		return "SBC A,(HL)"
 	}
-	c.opcodeMap[0xAF] = func() string {
-		c.XorReg(c.AF.hi)
-		return "XOR A"
+	c.opcodeMap[0x9F] = func() string {
+		c.SbcReg(c.AF.hi)
+		return "SBC A,A"
 	}
 	c.opcodeMap[0xA0] = func() string {
 		c.AndReg(c.BC.hi)
@@ -758,20 +976,49 @@ func (c *CPU) SetupOpcodeMap() {
 		return "AND L"
 	}
 	c.opcodeMap[0xA6] = func() string {
-		byte := c.mmu.ReadByte(c.HL.word)
-		*c.AF.hi &= byte
-		if *c.AF.hi == 0 {
-			c.SetZeroFlag()
-		} else {
-			c.UnsetZeroFlag()
-		}
-		c.UnsetSubtractionFlag()
-		c.SetHalfCarryFlag()
-		c.UnsetCarryFlag()
+		c.AndByte(c.mmu.ReadByte(c.HL.word))
 		c.PC.word++
 		c.cycles += 8
 		return "AND (HL)"
 	}
+	c.opcodeMap[0xA7] = func() string {
+		c.AndReg(c.AF.hi)
+		return "AND A"
+	}
+	c.opcodeMap[0xA8] = func() string {
+		c.XorReg(c.BC.hi)
+		return "XOR B"
+	}
+	c.opcodeMap[0xA9] = func() string {
+		c.XorReg(c.BC.lo)
+		return "XOR C"
+	}
+	c.opcodeMap[0xAA] = func() string {
+		c.XorReg(c.DE.hi)
+		return "XOR D"
+	}
+	c.opcodeMap[0xAB] = func() string {
+		c.XorReg(c.DE.lo)
+		return "XOR E"
+	}
+	c.opcodeMap[0xAC] = func() string {
+		c.XorReg(c.HL.hi)
+		return "XOR H"
+	}
+	c.opcodeMap[0xAD] = func() string {
+		c.XorReg(c.HL.lo)
+		return "XOR L"
+	}
+	c.opcodeMap[0xAE] = func() string {
+		c.XorByte(c.mmu.ReadByte(c.HL.word))
+		c.PC.word++
+		c.cycles += 8
+		return "XOR (HL)"
+	}
+	c.opcodeMap[0xAF] = func() string {
+		c.XorReg(c.AF.hi)
+		return "XOR A"
+	}
 	c.opcodeMap[0xB0] = func() string {
 		c.OrReg(c.BC.hi)
 		return "OR B"
@@ -797,16 +1044,7 @@ func (c *CPU) SetupOpcodeMap() {
 		return "OR L"
 	}
 	c.opcodeMap[0xB6] = func() string {
-		byte := c.mmu.ReadByte(c.HL.word)
-		*c.AF.hi |= byte
-		if *c.AF.hi == 0 {
-			c.SetZeroFlag()
-		} else {
-			c.UnsetZeroFlag()
-		}
-		c.UnsetSubtractionFlag()
-		c.UnsetHalfCarryFlag()
-		c.UnsetCarryFlag()
+		c.OrByte(c.mmu.ReadByte(c.HL.word))
 		c.PC.word++
 		c.cycles += 8
 		return "OR (HL)"
@@ -815,32 +1053,150 @@ func (c *CPU) SetupOpcodeMap() {
 		c.OrReg(c.AF.hi)
 		return "OR A"
 	}
+	c.opcodeMap[0xB8] = func() string {
+		c.CPReg(c.BC.hi)
+		return "CP B"
+	}
+	c.opcodeMap[0xB9] = func() string {
+		c.CPReg(c.BC.lo)
+		return "CP C"
+	}
+	c.opcodeMap[0xBA] = func() string {
+		c.CPReg(c.DE.hi)
+		return "CP D"
+	}
+	c.opcodeMap[0xBB] = func() string {
+		c.CPReg(c.DE.lo)
+		return "CP E"
+	}
+	c.opcodeMap[0xBC] = func() string {
+		c.CPReg(c.HL.hi)
+		return "CP H"
+	}
+	c.opcodeMap[0xBD] = func() string {
+		c.CPReg(c.HL.lo)
+		return "CP L"
+	}
 	c.opcodeMap[0xBE] = func() string {
 		c.CPByte(c.mmu.ReadByte(c.HL.word))
+		c.PC.word++
+		c.cycles += 8
 		return "CP (HL)"
 	}
-	c.opcodeMap[0xFB] = func() string {
-		c.CPByte(*c.AF.hi)
+	c.opcodeMap[0xBF] = func() string {
+		c.CPReg(c.AF.hi)
 		return "CP A"
 	}
+	c.opcodeMap[0xC6] = func() string {
+		c.AddByte(c.mmu.ReadByte(c.PC.word+1), false)
+		c.PC.word += 2
+		c.cycles += 8
+		return "ADD A,d8"
+	}
+	c.opcodeMap[0xCE] = func() string {
+		c.AddByte(c.mmu.ReadByte(c.PC.word+1), true)
+		c.PC.word += 2
+		c.cycles += 8
+		return "ADC A,d8"
+	}
+	c.opcodeMap[0xD6] = func() string {
+		c.SubByte(c.mmu.ReadByte(c.PC.word+1), false)
+		c.PC.word += 2
+		c.cycles += 8
+		return "SUB d8"
+	}
+	c.opcodeMap[0xDE] = func() string {
+		c.SubByte(c.mmu.ReadByte(c.PC.word+1), true)
+		c.PC.word += 2
+		c.cycles += 8
+		return "SBC A,d8"
+	}
+	c.opcodeMap[0xE6] = func() string {
+		c.AndByte(c.mmu.ReadByte(c.PC.word + 1))
+		c.PC.word += 2
+		c.cycles += 8
+		return "AND d8"
+	}
+	c.opcodeMap[0xEE] = func() string {
+		c.XorByte(c.mmu.ReadByte(c.PC.word + 1))
+		c.PC.word += 2
+		c.cycles += 8
+		return "XOR d8"
+	}
+	c.opcodeMap[0xF6] = func() string {
+		c.OrByte(c.mmu.ReadByte(c.PC.word + 1))
+		c.PC.word += 2
+		c.cycles += 8
+		return "OR d8"
+	}
 	c.opcodeMap[0xFE] = func() string {
 		c.CPByte(c.mmu.ReadByte(c.PC.word + 1))
-		c.PC.word++ // CP d8 is length 2 and CPByte only increases by 1
+		c.PC.word += 2
+		c.cycles += 8
 		return "CP d8"
 	}
 
 	// Misc.
+	c.opcodeMap[0x00] = func() string {
+		c.PC.word++
+		c.cycles += 4
+		return "NOP"
+	}
+	c.opcodeMap[0x27] = func() string {
+		c.DecimalAdjust()
+		return "DAA"
+	}
+	c.opcodeMap[0x2F] = func() string {
+		*c.AF.hi = ^*c.AF.hi
+		c.SetSubtractionFlag()
+		c.SetHalfCarryFlag()
+		c.PC.word++
+		c.cycles += 4
+		return "CPL"
+	}
+	c.opcodeMap[0x37] = func() string {
+		c.UnsetSubtractionFlag()
+		c.UnsetHalfCarryFlag()
+		c.SetCarryFlag()
+		c.PC.word++
+		c.cycles += 4
+		return "SCF"
+	}
+	c.opcodeMap[0x3F] = func() string {
+		c.UnsetSubtractionFlag()
+		c.UnsetHalfCarryFlag()
+		c.SetFlag(C, !c.GetCarryFlag())
+		c.PC.word++
+		c.cycles += 4
+		return "CCF"
+	}
 	c.opcodeMap[0xF3] = func() string {
-		//TODO: Implement
-		fmt.Println("Disable Interrupts")
+		c.ime = false
+		c.imeDelay = 0
 		c.PC.word++
 		c.cycles += 4
 		return "DI"
 	}
-	c.opcodeMap[0x00] = func() string {
+	c.opcodeMap[0xFB] = func() string {
+		// IME is only set after the instruction following EI has run
+		if !c.ime {
+			c.imeDelay = 2
+		}
 		c.PC.word++
 		c.cycles += 4
-		return "NOP"
+		return "EI"
+	}
+	c.opcodeMap[0x76] = func() string {
+		c.Halt()
+		return "HALT"
+	}
+	c.opcodeMap[0x10] = func() string {
+		// STOP is two bytes long, the second byte is ignored
+		c.stopped = true
+		c.mmu.WriteByte(0xFF04, 0)
+		c.PC.word += 2
+		c.cycles += 4
+		return "STOP"
 	}
 	c.opcodeMap[0xCB] = func() string {
 		cbop := c.cbOpcodeMap[c.mmu.ReadByte(c.PC.word+1)]()
@@ -849,20 +1205,69 @@ func (c *CPU) SetupOpcodeMap() {
 		return "CB " + cbop
 	}
 
-	// CB opcode map setup here
+	// CB opcode map setup here.
+	// CB opcodes are decoded from their encoding rather than listed one by one: bits 0-2 select the operand
+	// in the order B, C, D, E, H, L, (HL), A, bits 6-7 select the operation group (shift/rotate, BIT, RES, SET),
+	// and bits 3-5 select either the shift/rotate operation or the bit number.
 	c.cbOpcodeMap = make(map[uint8]func() string)
 
-	c.cbOpcodeMap[0x7C] = func() string {
-		c.CBBit(7, c.HL.hi)
-		return "BIT 7,H"
-	}
-	c.cbOpcodeMap[0x11] = func() string {
-		c.RotateLeft(c.BC.lo)
-		return "RL C"
-	}
-	c.cbOpcodeMap[0x37] = func() string {
-		c.CBBit(6, c.DE.lo)
-		return "BIT 6,E"
+	registers := [8]*uint8{c.BC.hi, c.BC.lo, c.DE.hi, c.DE.lo, c.HL.hi, c.HL.lo, nil, c.AF.hi}
+	registerNames := [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	shifts := [8]func(*uint8){
+		c.RotateLeftCarry,
+		c.RotateRightCarry,
+		c.RotateLeft,
+		c.RotateRight,
+		c.ShiftLeftArithmetic,
+		c.ShiftRightArithmetic,
+		c.Swap,
+		c.ShiftRightLogical,
+	}
+	shiftNames := [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+
+	for i := 0; i < 0x100; i++ {
+		opcode := uint8(i)
+		register := registers[opcode&7]
+		bit := (opcode >> 3) & 7
+
+		var operation func(*uint8)
+		var name string
+		// Extra cycles taken when the operand is (HL). BIT only reads memory, the rest read and write it.
+		hlCycles := uint64(8)
+
+		switch opcode >> 6 {
+		case 0:
+			operation = shifts[bit]
+			name = fmt.Sprintf("%s %s", shiftNames[bit], registerNames[opcode&7])
+		case 1:
+			operation = func(byte *uint8) { c.CBBit(bit, byte) }
+			name = fmt.Sprintf("BIT %d,%s", bit, registerNames[opcode&7])
+			hlCycles = 4
+		case 2:
+			operation = func(byte *uint8) { c.CBRes(bit, byte) }
+			name = fmt.Sprintf("RES %d,%s", bit, registerNames[opcode&7])
+		case 3:
+			operation = func(byte *uint8) { c.CBSet(bit, byte) }
+			name = fmt.Sprintf("SET %d,%s", bit, registerNames[opcode&7])
+		}
+
+		if register != nil {
+			c.cbOpcodeMap[opcode] = func() string {
+				operation(register)
+				return name
+			}
+			continue
+		}
+
+		c.cbOpcodeMap[opcode] = func() string {
+			byte := c.mmu.ReadByte(c.HL.word)
+			operation(&byte)
+			if opcode>>6 != 1 {
+				c.mmu.WriteByte(c.HL.word, byte)
+			}
+			c.cycles += hlCycles
+			return name
+		}
 	}
 }
 
@@ -879,46 +1284,45 @@ func (c *CPU) CBBit(bitNum uint8, byte *uint8) {
 	c.cycles += 4
 }
 
+// CBRes sets a given bit in a byte to 0.
+func (c *CPU) CBRes(bitNum uint8, byte *uint8) {
+	*byte &^= BitVal(bitNum)
+	c.PC.word++
+	c.cycles += 4
+}
+
+// CBSet sets a given bit in a byte to 1.
+func (c *CPU) CBSet(bitNum uint8, byte *uint8) {
+	*byte |= BitVal(bitNum)
+	c.PC.word++
+	c.cycles += 4
+}
+
 // CPByte compares a byte with the value in the A register, setting whichever flags are relevant to the result.
 func (c *CPU) CPByte(byte uint8) {
-	c.SetSubtractionFlag()
-	c.UnsetZeroFlag()
-	c.UnsetCarryFlag()
-	c.UnsetHalfCarryFlag()
-	if *c.AF.hi-byte == 0 {
-		c.SetZeroFlag()
-	}
-	if *c.AF.hi < byte {
-		c.SetCarryFlag()
-		c.SetHalfCarryFlag()
-	}
+	a := *c.AF.hi
+	c.SubByte(byte, false)
+	*c.AF.hi = a
+}
+
+// CPReg compares a register with the value in the A register.
+func (c *CPU) CPReg(register *uint8) {
+	c.CPByte(*register)
 	c.PC.word++
-	c.cycles += 8
+	c.cycles += 4
 }
 
 // RotateLeft rotates a byte left by 9, carries the overflow bit, and puts the carry bit in the 0th bit.
 func (c *CPU) RotateLeft(register *uint8) {
 	setCarry := CheckBit(register, 7)
 
-	*register = (*register << 1) | (*register >> 7)
-
+	*register <<= 1
 	if c.GetCarryFlag() {
 		*register |= 1
-	} else {
-		*register &= 254
-	}
-
-	if setCarry {
-		c.SetCarryFlag()
-	} else {
-		c.UnsetCarryFlag()
 	}
 
-	if *register == 0 {
-		c.UnsetZeroFlag()
-	} else {
-		c.SetZeroFlag()
-	}
+	c.SetFlag(C, setCarry)
+	c.SetFlag(Z, *register == 0)
 	c.UnsetHalfCarryFlag()
 	c.UnsetSubtractionFlag()
 
@@ -930,25 +1334,13 @@ func (c *CPU) RotateLeft(register *uint8) {
 func (c *CPU) RotateRight(register *uint8) {
 	setCarry := CheckBit(register, 0)
 
-	*register = (*register >> 1) | (*register << 7)
-
+	*register >>= 1
 	if c.GetCarryFlag() {
 		*register |= 128
-	} else {
-		*register &= 127
 	}
 
-	if setCarry {
-		c.SetCarryFlag()
-	} else {
-		c.UnsetCarryFlag()
-	}
-
-	if *register == 0 {
-		c.UnsetZeroFlag()
-	} else {
-		c.SetZeroFlag()
-	}
+	c.SetFlag(C, setCarry)
+	c.SetFlag(Z, *register == 0)
 	c.UnsetHalfCarryFlag()
 	c.UnsetSubtractionFlag()
 
@@ -958,16 +1350,10 @@ func (c *CPU) RotateRight(register *uint8) {
 
 // RotateLeftCarry rotates a byte left by 8 and carries the overflow bit into the carry flag and the 0th bit.
 func (c *CPU) RotateLeftCarry(register *uint8) {
+	c.SetFlag(C, CheckBit(register, 7))
+	*register = (*register << 1) | (*register >> 7)
 
-	if CheckBit(register, 7) {
-		c.SetCarryFlag()
-		*register |= 1
-	} else {
-		c.UnsetCarryFlag()
-		*register &= 254
-	}
-
-	c.UnsetZeroFlag()
+	c.SetFlag(Z, *register == 0)
 	c.UnsetHalfCarryFlag()
 	c.UnsetSubtractionFlag()
 	c.PC.word++
@@ -976,22 +1362,64 @@ func (c *CPU) RotateLeftCarry(register *uint8) {
 
 // RotateRightCarry rotates a byte right by 8 and carries the overflow bit into the carry flag and 7th bit.
 func (c *CPU) RotateRightCarry(register *uint8) {
+	c.SetFlag(C, CheckBit(register, 0))
+	*register = (*register >> 1) | (*register << 7)
 
-	if CheckBit(register, 0) {
-		c.SetCarryFlag()
-		*register |= 128
-	} else {
-		c.UnsetCarryFlag()
-		*register &= 127
-	}
+	c.SetFlag(Z, *register == 0)
+	c.UnsetHalfCarryFlag()
+	c.UnsetSubtractionFlag()
+	c.PC.word++
+	c.cycles += 4
+}
 
-	c.UnsetZeroFlag()
+// ShiftLeftArithmetic shifts a byte left by 1 into the carry flag and sets the 0th bit to 0.
+func (c *CPU) ShiftLeftArithmetic(register *uint8) {
+	c.SetFlag(C, CheckBit(register, 7))
+	*register <<= 1
+
+	c.SetFlag(Z, *register == 0)
+	c.UnsetHalfCarryFlag()
+	c.UnsetSubtractionFlag()
+	c.PC.word++
+	c.cycles += 4
+}
+
+// ShiftRightArithmetic shifts a byte right by 1 into the carry flag and leaves the 7th bit unchanged.
+func (c *CPU) ShiftRightArithmetic(register *uint8) {
+	c.SetFlag(C, CheckBit(register, 0))
+	*register = (*register >> 1) | (*register & 128)
+
+	c.SetFlag(Z, *register == 0)
+	c.UnsetHalfCarryFlag()
+	c.UnsetSubtractionFlag()
+	c.PC.word++
+	c.cycles += 4
+}
+
+// ShiftRightLogical shifts a byte right by 1 into the carry flag and sets the 7th bit to 0.
+func (c *CPU) ShiftRightLogical(register *uint8) {
+	c.SetFlag(C, CheckBit(register, 0))
+	*register >>= 1
+
+	c.SetFlag(Z, *register == 0)
 	c.UnsetHalfCarryFlag()
 	c.UnsetSubtractionFlag()
 	c.PC.word++
 	c.cycles += 4
 }
 
+// Swap swaps the upper and lower nibbles of a byte.
+func (c *CPU) Swap(register *uint8) {
+	*register = (*register << 4) | (*register >> 4)
+
+	c.SetFlag(Z, *register == 0)
+	c.UnsetHalfCarryFlag()
+	c.UnsetSubtractionFlag()
+	c.UnsetCarryFlag()
+	c.PC.word++
+	c.cycles += 4
+}
+
 // JRCond jumps to a relative position if condition is true.
 func (c *CPU) JRCond(condition bool) {
 	if condition {
@@ -1008,44 +1436,91 @@ func (c *CPU) JRCond(condition bool) {
 	}
 }
 
+// JPCond jumps to an absolute address if condition is true.
+func (c *CPU) JPCond(condition bool) {
+	if condition {
+		c.PC.word = c.mmu.ReadWord(c.PC.word + 1)
+		c.cycles += 16
+	} else {
+		c.PC.word += 3
+		c.cycles += 12
+	}
+}
+
+// CallCond pushes the address of the next instruction and jumps to an absolute address if condition is true.
+func (c *CPU) CallCond(condition bool) {
+	if condition {
+		address := c.mmu.ReadWord(c.PC.word + 1)
+		c.PushStack(c.PC.word + 3)
+		c.PC.word = address
+		c.cycles += 24
+	} else {
+		c.PC.word += 3
+		c.cycles += 12
+	}
+}
+
+// RetCond pops the return address off of the stack into PC if condition is true.
+func (c *CPU) RetCond(condition bool) {
+	if condition {
+		c.PC.word = c.PopStack()
+		c.cycles += 20
+	} else {
+		c.PC.word++
+		c.cycles += 8
+	}
+}
+
+// Rst pushes the address of the next instruction and jumps to one of the fixed restart vectors.
+func (c *CPU) Rst(vector uint16) {
+	c.PushStack(c.PC.word + 1)
+	c.PC.word = vector
+	c.cycles += 16
+}
+
+// PushStack decrements the stack pointer by 2 and writes a 16-bit word at the new top of the stack.
+func (c *CPU) PushStack(word uint16) {
+	c.SP.word -= 2
+	c.mmu.WriteWord(c.SP.word, word)
+}
+
+// PopStack reads the 16-bit word at the top of the stack and increments the stack pointer by 2.
+func (c *CPU) PopStack() uint16 {
+	word := c.mmu.ReadWord(c.SP.word)
+	c.SP.word += 2
+	return word
+}
+
 // PushWord pushes a 16-bit word onto the stack
 func (c *CPU) PushWord(word uint16) {
-	c.mmu.WriteWord(c.SP.word, word)
-	c.SP.word -= 2
+	c.PushStack(word)
 	c.PC.word++
 	c.cycles += 16
 }
 
 // PopWord pops a 16-bit word off of the stack into the location specified.
 func (c *CPU) PopWord(word *uint16) {
-	c.SP.word += 2
-	*word = c.mmu.ReadWord(c.SP.word)
+	*word = c.PopStack()
 	c.PC.word++
 	c.cycles += 12
 }
 
 // Inc8 increments an 8-bit register by 1.
 func (c *CPU) Inc8(register *uint8) {
+	c.SetFlag(H, *register&0xF == 0xF)
 	*register++
 	c.UnsetSubtractionFlag()
-	if *register == 0 {
-		c.SetZeroFlag()
-		c.UnsetHalfCarryFlag()
-	}
+	c.SetFlag(Z, *register == 0)
 	c.PC.word++
 	c.cycles += 4
 }
 
-// Dec8 increments an 8-bit register by 1.
+// Dec8 decrements an 8-bit register by 1.
 func (c *CPU) Dec8(register *uint8) {
+	c.SetFlag(H, *register&0xF == 0)
 	*register--
 	c.SetSubtractionFlag()
-	c.UnsetZeroFlag()
-	if *register == 0 {
-		c.SetZeroFlag()
-	} else if *register == 255 {
-		c.SetHalfCarryFlag()
-	}
+	c.SetFlag(Z, *register == 0)
 	c.PC.word++
 	c.cycles += 4
 }
@@ -1057,7 +1532,7 @@ func (c *CPU) Inc16(registerPair *uint16) {
 	c.cycles += 8
 }
 
-// Dec16 increments a 16-bit register pair by 1.
+// Dec16 decrements a 16-bit register pair by 1.
 func (c *CPU) Dec16(registerPair *uint16) {
 	*registerPair--
 	c.PC.word++
@@ -1092,112 +1567,229 @@ func (c *CPU) LdReg8Adr(register *uint8, address uint16) {
 	c.cycles += 8
 }
 
-// LdAdrA copies the value of register A into the memory address specified.
-func (c *CPU) LdAdrA(address uint16) {
-	c.mmu.WriteByte(address, *c.AF.hi)
+// LdAdrReg8 copies the value of a register into the memory address specified.
+func (c *CPU) LdAdrReg8(address uint16, register *uint8) {
+	c.mmu.WriteByte(address, *register)
 	c.PC.word++
 	c.cycles += 8
 }
 
-// AddReg8 adds a register to A.
-func (c *CPU) AddReg8(register *uint8) {
+// LdAdrA copies the value of register A into the memory address specified.
+func (c *CPU) LdAdrA(address uint16) {
+	c.LdAdrReg8(address, c.AF.hi)
+}
+
+// AddByte adds a byte to A, plus the carry flag if withCarry is true.
+func (c *CPU) AddByte(byte uint8, withCarry bool) {
+	carry := uint8(0)
+	if withCarry && c.GetCarryFlag() {
+		carry = 1
+	}
+	a := *c.AF.hi
+	sum := uint16(a) + uint16(byte) + uint16(carry)
+	*c.AF.hi = uint8(sum)
+
+	c.SetFlag(Z, *c.AF.hi == 0)
 	c.UnsetSubtractionFlag()
+	c.SetFlag(H, (a&0xF)+(byte&0xF)+carry > 0xF)
+	c.SetFlag(C, sum > 0xFF)
+}
+
+// SubByte subtracts a byte from A, minus the carry flag if withCarry is true.
+func (c *CPU) SubByte(byte uint8, withCarry bool) {
+	carry := uint8(0)
+	if withCarry && c.GetCarryFlag() {
+		carry = 1
+	}
+	a := *c.AF.hi
+	*c.AF.hi = a - byte - carry
+
+	c.SetFlag(Z, *c.AF.hi == 0)
+	c.SetSubtractionFlag()
+	c.SetFlag(H, uint16(a&0xF) < uint16(byte&0xF)+uint16(carry))
+	c.SetFlag(C, uint16(a) < uint16(byte)+uint16(carry))
+}
+
+// AndByte ands a byte with register A and stores the result in A.
+func (c *CPU) AndByte(byte uint8) {
+	*c.AF.hi &= byte
+	c.SetFlag(Z, *c.AF.hi == 0)
+	c.UnsetSubtractionFlag()
+	c.SetHalfCarryFlag()
 	c.UnsetCarryFlag()
+}
+
+// XorByte xors a byte with register A and stores the result in A.
+func (c *CPU) XorByte(byte uint8) {
+	*c.AF.hi ^= byte
+	c.SetFlag(Z, *c.AF.hi == 0)
+	c.UnsetSubtractionFlag()
 	c.UnsetHalfCarryFlag()
-	c.UnsetZeroFlag()
+	c.UnsetCarryFlag()
+}
 
-	*c.AF.hi += *register
+// OrByte ors a byte with register A and stores the result in A.
+func (c *CPU) OrByte(byte uint8) {
+	*c.AF.hi |= byte
+	c.SetFlag(Z, *c.AF.hi == 0)
+	c.UnsetSubtractionFlag()
+	c.UnsetHalfCarryFlag()
+	c.UnsetCarryFlag()
+}
 
-	if *c.AF.hi < *register {
-		c.SetCarryFlag()
-		c.SetHalfCarryFlag()
-	}
-	if *c.AF.hi == 0 {
-		c.SetZeroFlag()
-	}
+// AddReg8 adds a register to A.
+func (c *CPU) AddReg8(register *uint8) {
+	c.AddByte(*register, false)
+	c.PC.word++
+	c.cycles += 4
+}
+
+// AdcReg8 adds a register and the carry flag to A.
+func (c *CPU) AdcReg8(register *uint8) {
+	c.AddByte(*register, true)
 	c.PC.word++
 	c.cycles += 4
 }
 
 // AddReg16 adds a register to HL, storing the result in HL.
 func (c *CPU) AddReg16(word *uint16) {
+	value := *word
+	sum := uint32(c.HL.word) + uint32(value)
+
 	c.UnsetSubtractionFlag()
-	c.UnsetCarryFlag()
-	c.UnsetHalfCarryFlag()
-	c.HL.word += *word
+	c.SetFlag(H, (c.HL.word&0xFFF)+(value&0xFFF) > 0xFFF)
+	c.SetFlag(C, sum > 0xFFFF)
+	c.HL.word = uint16(sum)
 
 	c.PC.word++
 	c.cycles += 8
 }
 
+// SPPlusOffset returns SP plus the signed byte following the opcode.
+// The H and C flags are set from the unsigned addition of the low bytes, and Z and N are reset.
+func (c *CPU) SPPlusOffset() uint16 {
+	offset := c.mmu.ReadByte(c.PC.word + 1)
+
+	c.UnsetZeroFlag()
+	c.UnsetSubtractionFlag()
+	c.SetFlag(H, (c.SP.word&0xF)+uint16(offset&0xF) > 0xF)
+	c.SetFlag(C, (c.SP.word&0xFF)+uint16(offset) > 0xFF)
+
+	return c.SP.word + uint16(int8(offset))
+}
+
 // SubReg subtracts a register from A.
 func (c *CPU) SubReg(register *uint8) {
-	c.SetSubtractionFlag()
-	c.UnsetCarryFlag()
-	c.UnsetHalfCarryFlag()
-	c.UnsetZeroFlag()
-	if *c.AF.hi < *register {
-		c.SetCarryFlag()
-		c.SetHalfCarryFlag()
-	} else if *c.AF.hi == *register {
-		c.SetZeroFlag()
-	}
-	*c.AF.hi -= *register
+	c.SubByte(*register, false)
+	c.PC.word++
+	c.cycles += 4
+}
+
+// SbcReg subtracts a register and the carry flag from A.
+func (c *CPU) SbcReg(register *uint8) {
+	c.SubByte(*register, true)
 	c.PC.word++
 	c.cycles += 4
 }
 
 // XorReg xors a register with register A and stores the result in A.
 func (c *CPU) XorReg(register *uint8) {
-	*c.AF.hi ^= *register
-	if *c.AF.hi == 0 {
-		c.SetZeroFlag()
-	} else {
-		c.UnsetZeroFlag()
-	}
-	c.UnsetSubtractionFlag()
-	c.UnsetHalfCarryFlag()
-	c.UnsetCarryFlag()
+	c.XorByte(*register)
 	c.PC.word++
 	c.cycles += 4
 }
 
 // OrReg ors a register with register A and stores the result in A.
 func (c *CPU) OrReg(register *uint8) {
-	*c.AF.hi |= *register
-	if *c.AF.hi == 0 {
-		c.SetZeroFlag()
-	} else {
-		c.UnsetZeroFlag()
-	}
-	c.UnsetSubtractionFlag()
-	c.UnsetHalfCarryFlag()
-	c.UnsetCarryFlag()
+	c.OrByte(*register)
 	c.PC.word++
 	c.cycles += 4
 }
 
 // AndReg ands a register with register A and stores the result in A.
 func (c *CPU) AndReg(register *uint8) {
-	*c.AF.hi &= *register
-	if *c.AF.hi == 0 {
-		c.SetZeroFlag()
+	c.AndByte(*register)
+	c.PC.word++
+	c.cycles += 4
+}
+
+// DecimalAdjust adjusts A to be a valid binary-coded decimal after an addition or subtraction of two BCD numbers.
+func (c *CPU) DecimalAdjust() {
+	a := *c.AF.hi
+	carry := c.GetCarryFlag()
+
+	if !c.GetSubtractionFlag() {
+		if carry || a > 0x99 {
+			a += 0x60
+			carry = true
+		}
+		if c.GetHalfCarryFlag() || a&0xF > 0x9 {
+			a += 0x06
+		}
 	} else {
-		c.UnsetZeroFlag()
+		if carry {
+			a -= 0x60
+		}
+		if c.GetHalfCarryFlag() {
+			a -= 0x06
+		}
 	}
-	c.UnsetSubtractionFlag()
-	c.SetHalfCarryFlag()
-	c.UnsetCarryFlag()
+
+	*c.AF.hi = a
+	c.SetFlag(Z, a == 0)
+	c.UnsetHalfCarryFlag()
+	c.SetFlag(C, carry)
 	c.PC.word++
 	c.cycles += 4
 }
 
-// Start writes the bootloader data into the 0x100-0xFFF range of the MMU and returns a stepping function.
+// LoadBootROM sets the bootloader data, which must be exactly 0x100 bytes long.
+func (c *CPU) LoadBootROM(data []uint8) error {
+	if len(data) != BLSIZE {
+		return fmt.Errorf("boot ROM is 0x%X bytes long, expected 0x%X", len(data), BLSIZE)
+	}
+	copy(c.bootloader[:], data)
+	return nil
+}
+
+// SetTrace sets a writer to log each executed instruction to, or nil to stop tracing.
+func (c *CPU) SetTrace(w io.Writer) {
+	c.trace = w
+}
+
+// SkipBoot sets the registers to the state a boot ROM leaves them in, with PC at the cartridge's entry point 0x0100.
+// The bootloader isn't mapped when the CPU starts.
+func (c *CPU) SkipBoot(state BootState, headerChecksum uint8) {
+	c.AF.word = state.AF&0xFF00 | uint16(state.HeaderFlags(headerChecksum))
+	c.BC.word = state.BC
+	c.DE.word = state.DE
+	c.HL.word = state.HL
+	c.SP.word = state.SP
+	c.PC.word = 0x0100
+	c.bootSkipped = true
+}
+
+// SerializeState writes or reads the registers and execution state.
+// Only the register words are stored, and loading writes them in place, so the hi and lo pointers into them
+// stay valid.
+func (c *CPU) SerializeState(s *StateSerializer) {
+	for _, r := range []*Register{&c.AF, &c.BC, &c.DE, &c.HL, &c.PC, &c.SP} {
+		s.Uint16(&r.word)
+	}
+	s.Uint64(&c.cycles)
+	s.Bool(&c.ime)
+	s.Uint8(&c.imeDelay)
+	s.Bool(&c.halted)
+	s.Bool(&c.haltBug)
+	s.Bool(&c.stopped)
+}
+
+// Start maps the bootloader data over the 0x0000-0x00FF range of the MMU, unless the boot was skipped,
+// and returns a stepping function.
 // This returned function takes one CPU step each time it is called.
 func (c *CPU) Start() func() uint64 {
-	for i, v := range c.bootloader {
-		address := uint16(i)
-		c.mmu.WriteByte(address, v)
+	if !c.bootSkipped {
+		c.mmu.LoadBootROM(c.bootloader[:])
 	}
 
 	// var lastIns string
@@ -1206,9 +1798,51 @@ func (c *CPU) Start() func() uint64 {
 
 		var startCycles = c.cycles
 
-		_ = c.opcodeMap[c.mmu.ReadByte(c.PC.word)]()
+		if c.imeDelay > 0 {
+			c.imeDelay--
+			if c.imeDelay == 0 {
+				c.ime = true
+			}
+		}
 
-		if c.PC.word == 0x100 {
+		// A stopped CPU sleeps until a joypad button is pressed.
+		if c.stopped {
+			ifReg := c.mmu.ReadByte(IFADDR)
+			if !CheckBit(&ifReg, JOYPAD) {
+				c.cycles += 4
+				return c.cycles - startCycles
+			}
+			c.stopped = false
+		}
+
+		// A halted CPU idles until any enabled interrupt is requested, whether or not IME is set.
+		if c.halted {
+			if c.PendingInterrupts() == 0 {
+				c.cycles += 4
+				return c.cycles - startCycles
+			}
+			c.halted = false
+		}
+
+		if c.ServiceInterrupts() {
+			return c.cycles - startCycles
+		}
+
+		pc := c.PC.word
+		opcode := c.mmu.ReadByte(c.PC.word)
+		if c.haltBug {
+			// The HALT bug fails to increment PC after this fetch, so the opcode byte is also read as
+			// the first operand byte. Starting one byte early makes the operand reads land on it.
+			c.haltBug = false
+			c.PC.word--
+		}
+		name := c.opcodeMap[opcode]()
+		if c.trace != nil {
+			c.TraceInstruction(pc, opcode, name)
+		}
+
+		// The check is for the state the DMG boot ROM leaves, which a skipped boot of another model doesn't match
+		if c.PC.word == 0x100 && !c.bootSkipped {
 			if err := c.CheckMemoryAfterBoot(); err != nil {
 				// panic(err)
 			}
@@ -1227,41 +1861,83 @@ func (c *CPU) Start() func() uint64 {
 	}
 }
 
+// PendingInterrupts returns the interrupts which are both requested in IF and enabled in IE.
+func (c *CPU) PendingInterrupts() uint8 {
+	return c.mmu.ReadByte(IEADDR) & c.mmu.ReadByte(IFADDR) & 0x1F
+}
+
+// ServiceInterrupts jumps to the handler of the highest priority pending interrupt if IME is set.
+// Servicing an interrupt clears IME and its IF bit, pushes PC, and takes 20 cycles.
+// It returns true if an interrupt was serviced.
+func (c *CPU) ServiceInterrupts() bool {
+	pending := c.PendingInterrupts()
+	if !c.ime || pending == 0 {
+		return false
+	}
+
+	for bit := uint8(VBLANK); bit <= JOYPAD; bit++ {
+		if CheckBit(&pending, bit) {
+			c.ime = false
+			c.mmu.WriteByte(IFADDR, c.mmu.ReadByte(IFADDR)&^BitVal(bit))
+			c.PushStack(c.PC.word)
+			c.PC.word = INTERRUPTVECTOR + 8*uint16(bit)
+			c.cycles += 20
+			break
+		}
+	}
+	return true
+}
+
+// Halt stops the CPU until an interrupt is pending.
+// If IME is not set and an interrupt is already pending, the CPU doesn't halt and instead triggers the HALT bug.
+func (c *CPU) Halt() {
+	if !c.ime && c.PendingInterrupts() != 0 {
+		c.haltBug = true
+	} else {
+		c.halted = true
+	}
+	c.PC.word++
+	c.cycles += 4
+}
+
 // CheckMemoryAfterBoot checks to ensure the registers and memory are set to the correct values when the bootloader is finished.
 // If any values are incorrect, the function returns the faulty values.
 func (c *CPU) CheckMemoryAfterBoot() error {
-	var pts = []uint16{0xFF05, 0xFF06, 0xFF07, 0xFF10, 0xFF11, 0xFF12, 0xFF14, 0xFF16, 0xFF17, 0xFF19, 0xFF1A, 0xFF1B, 0xFF1C, 0xFF1E, 0xFF20, 0xFF21, 0xFF22, 0xFF23, 0xFF24, 0xFF25, 0xFF26, 0xFF40, 0xFF42, 0xFF43, 0xFF45, 0xFF47, 0xFF48, 0xFF49, 0xFF4A, 0xFF4B, 0xFFFF}
-	var vals = []uint8{0, 0, 0, 0x80, 0xBF, 0xF3, 0xBF, 0x3F, 0, 0xBF, 0x7F, 0xFF, 0x9F, 0xBF, 0xFF, 0, 0, 0xBF, 0x77, 0xF3, 0xF1, 0x91, 0, 0, 0, 0xFC, 0xFF, 0xFF, 0, 0, 0}
+	var state = BOOTSTATES[MODELDMG]
 
 	var flag = false
 	var errString = "invalid memory values\n"
 
-	if c.AF.word != 0x01B0 {
+	if c.AF.word&0xFF00 != state.AF&0xFF00 {
 		flag = true
-		errString += fmt.Sprintf("AF = %X, should be %X\n", c.AF.word, 0x01B0)
+		errString += fmt.Sprintf("A = %X, should be %X\n", *c.AF.hi, state.AF>>8)
 	}
-	if c.BC.word != 0x0013 {
+	if c.BC.word != state.BC {
 		flag = true
-		errString += fmt.Sprintf("BC = %X, should be %X\n", c.BC.word, 0x0013)
+		errString += fmt.Sprintf("BC = %X, should be %X\n", c.BC.word, state.BC)
 	}
-	if c.DE.word != 0x00D8 {
+	if c.DE.word != state.DE {
 		flag = true
-		errString += fmt.Sprintf("DE = %X, should be %X\n", c.DE.word, 0x00D8)
+		errString += fmt.Sprintf("DE = %X, should be %X\n", c.DE.word, state.DE)
 	}
-	if c.HL.word != 0x014D {
+	if c.HL.word != state.HL {
 		flag = true
-		errString += fmt.Sprintf("HL = %X, should be %X\n", c.HL.word, 0x014D)
+		errString += fmt.Sprintf("HL = %X, should be %X\n", c.HL.word, state.HL)
 	}
 
-	for ix, v := range pts {
-		if c.mmu.memory[v] != vals[ix] {
-			errString += fmt.Sprintf("$%X = %X, should be %X\n", v, c.mmu.memory[v], vals[ix])
+	for _, v := range POSTBOOTIO {
+		want := v.value
+		if v.address < 0xFF80 {
+			want |= ioReadMasks[v.address-0xFF00]
+		}
+		if read := c.mmu.ReadByte(v.address); read != want {
+			errString += fmt.Sprintf("$%X = %X, should be %X\n", v.address, read, want)
 			flag = true
 		}
 	}
 
 	if flag {
-		return fmt.Errorf(errString)
+		return errors.New(errString)
 	}
 
 	return nil
@@ -1308,6 +1984,15 @@ func (c *CPU) UnsetHalfCarryFlag() {
 	*c.AF.lo &^= BitVal(H)
 }
 
+// SetFlag sets a flag to 1 if value is true and to 0 otherwise.
+func (c *CPU) SetFlag(flag uint8, value bool) {
+	if value {
+		*c.AF.lo |= BitVal(flag)
+	} else {
+		*c.AF.lo &^= BitVal(flag)
+	}
+}
+
 // GetZeroFlag returns true if the zero flag is set.
 func (c *CPU) GetZeroFlag() bool {
 	return CheckBit(c.AF.lo, Z)
@@ -1333,6 +2018,13 @@ func (c *CPU) PrintInstruction(name string) {
 	fmt.Printf("Step %d, %s", c.cycles, name)
 }
 
+// TraceInstruction writes the location, opcode and name of an executed instruction and the registers after it to
+// the trace writer.
+func (c *CPU) TraceInstruction(location uint16, opcode uint8, name string) {
+	fmt.Fprintf(c.trace, "%04X %02X %-16s AF=%04X BC=%04X DE=%04X HL=%04X SP=%04X cycles=%d\n",
+		location, opcode, name, c.AF.word, c.BC.word, c.DE.word, c.HL.word, c.SP.word, c.cycles)
+}
+
 // PrintRegisters prints the stack pointer location and data, and the values in each register except the flag register.
 func (c *CPU) PrintRegisters() {
 	fmt.Printf("\tStack pointer: %X ($%X) \n\t\tA: %X, F: %X, B: %X, C: %X, D: %X, E: %X, H: %X, L: %X\n",
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -9,32 +11,169 @@ func TestCPUInstructions(t *testing.T) {
 	cpu := &(CPU{})
 	cpu.Reset(mmu)
 
-	// notImplemented := []uint8{}
-	// nonExistent := utils.Set{}
-	// for _, v := range []uint8{0xD3, 0xE3, 0xE4, 0xF4, 0xDB, 0xEB, 0xEC, 0xFC, 0xDD, 0xED, 0xFD} {
-	// 	nonExistent.Add(v)
-	// }
-
-	// in := true
-	// for i := 0; i < 0x100; i++ {
-
-	// 	if !nonExistent.Contains(uint8(i)) {
-	// 		_, in = cpu.opcodeMap[uint8(i)]
-	// 		if !in {
-	// 			notImplemented = append(notImplemented, uint8(i))
-	// 		}
-	// 	}
-	// }
-	// if len(notImplemented) != 0 {
-	// 	t.Errorf("The following opcodes have not been implemented:\n")
-	// 	formatStr := strings.Repeat("%X, ", len(notImplemented))
-	// 	tmp := make([]interface{}, len(notImplemented))
-	// 	for i, val := range notImplemented {
-	// 		tmp[i] = val
-	// 	}
-	// 	t.Skipf(formatStr, tmp...)
-	// }
+	nonExistent := map[uint8]bool{}
+	for _, v := range []uint8{0xD3, 0xE3, 0xE4, 0xF4, 0xDB, 0xEB, 0xEC, 0xFC, 0xDD, 0xED, 0xFD} {
+		nonExistent[v] = true
+	}
+
+	notImplemented := []string{}
+	for i := 0; i < 0x100; i++ {
+		_, in := cpu.opcodeMap[uint8(i)]
+		if !nonExistent[uint8(i)] && !in {
+			notImplemented = append(notImplemented, fmt.Sprintf("%02X", i))
+		}
+		if nonExistent[uint8(i)] && in {
+			t.Errorf("Opcode %02X does not exist but is in the opcode map", i)
+		}
+	}
+	if len(notImplemented) != 0 {
+		t.Errorf("The following opcodes have not been implemented: %s", strings.Join(notImplemented, ", "))
+	}
+}
+
+func TestInstructionTiming(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	tables := []struct {
+		program []uint8
+		flags   uint8
+		cycles  uint64
+		length  uint16
+	}{
+		{[]uint8{0x00}, 0, 4, 1},                 // NOP
+		{[]uint8{0x34}, 0, 12, 1},                // INC (HL)
+		{[]uint8{0x2A}, 0, 8, 1},                 // LD A,(HL+)
+		{[]uint8{0x08, 0x00, 0xC0}, 0, 20, 3},    // LD (a16),SP
+		{[]uint8{0xC2, 0x00, 0x20}, 0x80, 12, 3}, // JP NZ,a16 not taken
+		{[]uint8{0xC4, 0x00, 0x20}, 0x80, 12, 3}, // CALL NZ,a16 not taken
+		{[]uint8{0xC0}, 0x80, 8, 1},              // RET NZ not taken
+		{[]uint8{0xF8, 0x01}, 0, 12, 2},          // LD HL,SP+r8
+		{[]uint8{0xE8, 0x01}, 0, 16, 2},          // ADD SP,r8
+		{[]uint8{0xCE, 0x01}, 0, 8, 2},           // ADC A,d8
+		{[]uint8{0x9E}, 0, 8, 1},                 // SBC A,(HL)
+		{[]uint8{0xF2}, 0, 8, 1},                 // LD A,(C)
+		{[]uint8{0xFA, 0x00, 0xC0}, 0, 16, 3},    // LD A,(a16)
+	}
+
+	for _, table := range tables {
+		for i, v := range table.program {
+			mmu.WriteByte(0xC000+uint16(i), v)
+		}
+		cpu.PC.word = 0xC000
+		cpu.SP.word = 0xDFFE
+		cpu.HL.word = 0xC100
+		*cpu.AF.lo = table.flags
+
+		startCycles := cpu.cycles
+		name := cpu.opcodeMap[table.program[0]]()
+		if cycles := cpu.cycles - startCycles; cycles != table.cycles {
+			t.Errorf("%s took %d cycles instead of %d", name, cycles, table.cycles)
+		}
+		if cpu.PC.word != 0xC000+table.length {
+			t.Errorf("%s left PC at %X instead of %X", name, cpu.PC.word, 0xC000+table.length)
+		}
+	}
+}
+
+func TestConditionalCallRet(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	// CALL Z,$D000 taken, then RET Z taken back to the next instruction
+	mmu.WriteByte(0xC000, 0xCC)
+	mmu.WriteWord(0xC001, 0xD000)
+	mmu.WriteByte(0xD000, 0xC8)
+	cpu.PC.word = 0xC000
+	cpu.SP.word = 0xDFFE
+	cpu.SetZeroFlag()
+
+	cpu.opcodeMap[0xCC]()
+	if cpu.PC.word != 0xD000 || cpu.SP.word != 0xDFFC {
+		t.Errorf("CALL Z jumped to %X with SP %X, should be D000 and DFFC", cpu.PC.word, cpu.SP.word)
+	}
+	if mmu.ReadWord(0xDFFC) != 0xC003 {
+		t.Errorf("CALL Z pushed %X instead of C003", mmu.ReadWord(0xDFFC))
+	}
+
+	startCycles := cpu.cycles
+	cpu.opcodeMap[0xC8]()
+	if cpu.PC.word != 0xC003 || cpu.SP.word != 0xDFFE {
+		t.Errorf("RET Z returned to %X with SP %X, should be C003 and DFFE", cpu.PC.word, cpu.SP.word)
+	}
+	if cpu.cycles-startCycles != 20 {
+		t.Errorf("RET Z taken took %d cycles instead of 20", cpu.cycles-startCycles)
+	}
+}
+
+func TestPushPopAF(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	cpu.SP.word = 0xDFFE
+	cpu.BC.word = 0x12FF
+	cpu.opcodeMap[0xC5]()
+	cpu.opcodeMap[0xF1]()
+
+	if cpu.AF.word != 0x12F0 {
+		t.Errorf("POP AF gave %X instead of 12F0", cpu.AF.word)
+	}
+}
+
+func TestArithmeticFlags(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	tables := []struct {
+		name   string
+		opcode uint8
+		A      uint8
+		B      uint8
+		carry  bool
+		result uint8
+		flags  uint8
+	}{
+		{"ADD A,B", 0x80, 0x3A, 0xC6, false, 0x00, 0xB0},
+		{"ADD A,B", 0x80, 0x3C, 0x12, false, 0x4E, 0x00},
+		{"ADD A,B", 0x80, 0x0F, 0x01, false, 0x10, 0x20},
+		{"ADC A,B", 0x88, 0xE1, 0x0F, true, 0xF1, 0x20},
+		{"ADC A,B", 0x88, 0xE1, 0x3B, true, 0x1D, 0x10},
+		{"SUB B", 0x90, 0x3E, 0x3E, false, 0x00, 0xC0},
+		{"SUB B", 0x90, 0x3E, 0x0F, false, 0x2F, 0x60},
+		{"SUB B", 0x90, 0x3E, 0x40, false, 0xFE, 0x50},
+		{"SBC A,B", 0x98, 0x3B, 0x2A, true, 0x10, 0x40},
+		{"SBC A,B", 0x98, 0x3B, 0x4F, true, 0xEB, 0x70},
+		{"CP B", 0xB8, 0x3C, 0x2F, false, 0x3C, 0x60},
+		{"CP B", 0xB8, 0x3C, 0x3C, false, 0x3C, 0xC0},
+		{"AND B", 0xA0, 0x5A, 0x3F, false, 0x1A, 0x20},
+		{"INC B", 0x04, 0x00, 0x0F, false, 0x00, 0x20},
+		{"DEC B", 0x05, 0x00, 0x10, false, 0x00, 0x60},
+		{"DAA", 0x27, 0x7D, 0x00, false, 0x83, 0x00},
+		{"DAA", 0x27, 0x99, 0x00, true, 0xF9, 0x10},
+		{"CPL", 0x2F, 0x35, 0x00, false, 0xCA, 0x60},
+		{"CCF", 0x3F, 0x00, 0x00, true, 0x00, 0x00},
+		{"SCF", 0x37, 0x00, 0x00, false, 0x00, 0x10},
+	}
 
+	for _, table := range tables {
+		cpu.PC.word = 0xC000
+		*cpu.AF.hi = table.A
+		*cpu.BC.hi = table.B
+		*cpu.AF.lo = 0
+		cpu.SetFlag(C, table.carry)
+		cpu.opcodeMap[table.opcode]()
+
+		if *cpu.AF.hi != table.result {
+			t.Errorf("%s with A=%X, B=%X gave A=%X instead of %X", table.name, table.A, table.B, *cpu.AF.hi, table.result)
+		}
+		if *cpu.AF.lo != table.flags {
+			t.Errorf("%s with A=%X, B=%X gave flags %08b instead of %08b", table.name, table.A, table.B, *cpu.AF.lo, table.flags)
+		}
+	}
 }
 
 func TestRL(t *testing.T) {
@@ -109,8 +248,9 @@ func TestRLC(t *testing.T) {
 		output uint8
 		carry  bool
 	}{
-		{16, 16, false},
-		{128, 129, true},
+		{16, 32, false},
+		{128, 1, true},
+		{129, 3, true},
 	}
 
 	for _, table := range tables {
@@ -136,8 +276,9 @@ func TestRRC(t *testing.T) {
 		output uint8
 		carry  bool
 	}{
-		{16, 16, false},
-		{1, 129, true},
+		{16, 8, false},
+		{1, 128, true},
+		{129, 192, true},
 	}
 
 	for _, table := range tables {
@@ -369,3 +510,301 @@ func TestAndReg(t *testing.T) {
 		}
 	}
 }
+
+// runCB executes a single CB-prefixed instruction from WRAM and returns its name and cycle count.
+func runCB(cpu *CPU, opcode uint8) (string, uint64) {
+	cpu.mmu.WriteByte(0xC000, 0xCB)
+	cpu.mmu.WriteByte(0xC001, opcode)
+	cpu.PC.word = 0xC000
+	startCycles := cpu.cycles
+	name := cpu.opcodeMap[0xCB]()
+	return name, cpu.cycles - startCycles
+}
+
+func TestCBInstructions(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	if len(cpu.cbOpcodeMap) != 0x100 {
+		t.Errorf("CB opcode map has %d entries instead of 256", len(cpu.cbOpcodeMap))
+	}
+
+	tables := []struct {
+		opcode uint8
+		name   string
+		cycles uint64
+	}{
+		{0x00, "CB RLC B", 8},
+		{0x06, "CB RLC (HL)", 16},
+		{0x1F, "CB RR A", 8},
+		{0x37, "CB SWAP A", 8},
+		{0x46, "CB BIT 0,(HL)", 12},
+		{0x7C, "CB BIT 7,H", 8},
+		{0x86, "CB RES 0,(HL)", 16},
+		{0xB9, "CB RES 7,C", 8},
+		{0xC2, "CB SET 0,D", 8},
+		{0xFE, "CB SET 7,(HL)", 16},
+	}
+
+	for _, table := range tables {
+		cpu.HL.word = 0xC100
+		name, cycles := runCB(cpu, table.opcode)
+		if name != table.name {
+			t.Errorf("CB %02X decoded as %s instead of %s", table.opcode, name, table.name)
+		}
+		if cycles != table.cycles {
+			t.Errorf("%s took %d cycles instead of %d", name, cycles, table.cycles)
+		}
+		if cpu.PC.word != 0xC002 {
+			t.Errorf("%s left PC at %X instead of C002", name, cpu.PC.word)
+		}
+	}
+}
+
+func TestCBShifts(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	tables := []struct {
+		opcode uint8
+		value  uint8
+		carry  bool
+		output uint8
+		flags  uint8
+	}{
+		{0x00, 0x85, false, 0x0B, 0x10}, // RLC B
+		{0x00, 0x00, false, 0x00, 0x80}, // RLC B
+		{0x08, 0x01, false, 0x80, 0x10}, // RRC B
+		{0x10, 0x80, false, 0x00, 0x90}, // RL B
+		{0x10, 0x11, true, 0x23, 0x00},  // RL B
+		{0x18, 0x01, false, 0x00, 0x90}, // RR B
+		{0x18, 0x8A, true, 0xC5, 0x00},  // RR B
+		{0x20, 0x80, false, 0x00, 0x90}, // SLA B
+		{0x20, 0xFF, false, 0xFE, 0x10}, // SLA B
+		{0x28, 0x8A, false, 0xC5, 0x00}, // SRA B
+		{0x28, 0x01, false, 0x00, 0x90}, // SRA B
+		{0x30, 0x00, true, 0x00, 0x80},  // SWAP B
+		{0x30, 0xF0, true, 0x0F, 0x00},  // SWAP B
+		{0x38, 0x01, false, 0x00, 0x90}, // SRL B
+		{0x38, 0xFF, false, 0x7F, 0x10}, // SRL B
+	}
+
+	for _, table := range tables {
+		*cpu.BC.hi = table.value
+		*cpu.AF.lo = 0
+		cpu.SetFlag(C, table.carry)
+		name, _ := runCB(cpu, table.opcode)
+
+		if *cpu.BC.hi != table.output {
+			t.Errorf("%s %X gave %X instead of %X", name, table.value, *cpu.BC.hi, table.output)
+		}
+		if *cpu.AF.lo != table.flags {
+			t.Errorf("%s %X gave flags %08b instead of %08b", name, table.value, *cpu.AF.lo, table.flags)
+		}
+	}
+}
+
+func TestCBBitResSet(t *testing.T) {
+	mmu := &(MMU{})
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+
+	tables := []struct {
+		opcode uint8
+		value  uint8
+		output uint8
+		zero   bool
+	}{
+		{0x7E, 0x80, 0x80, false}, // BIT 7,(HL)
+		{0x7E, 0x7F, 0x7F, true},  // BIT 7,(HL)
+		{0x46, 0xFE, 0xFE, true},  // BIT 0,(HL)
+		{0x9E, 0xFF, 0xF7, false}, // RES 3,(HL)
+		{0xBE, 0x80, 0x00, false}, // RES 7,(HL)
+		{0xDE, 0x00, 0x08, false}, // SET 3,(HL)
+		{0xC6, 0xFE, 0xFF, false}, // SET 0,(HL)
+	}
+
+	for _, table := range tables {
+		cpu.HL.word = 0xC100
+		mmu.WriteByte(0xC100, table.value)
+		*cpu.AF.lo = 0
+		name, _ := runCB(cpu, table.opcode)
+
+		if mmu.ReadByte(0xC100) != table.output {
+			t.Errorf("%s on %X gave %X instead of %X", name, table.value, mmu.ReadByte(0xC100), table.output)
+		}
+		if table.opcode < 0x80 && cpu.GetZeroFlag() != table.zero {
+			t.Errorf("%s on %X gave zero = %v instead of %v", name, table.value, cpu.GetZeroFlag(), table.zero)
+		}
+	}
+}
+
+func TestInterruptDispatch(t *testing.T) {
+	tables := []struct {
+		name      string
+		interrupt uint8
+		vector    uint16
+	}{
+		{"VBlank", VBLANK, 0x40},
+		{"STAT", LCDSTAT, 0x48},
+		{"Timer", TIMER, 0x50},
+		{"Serial", SERIAL, 0x58},
+		{"Joypad", JOYPAD, 0x60},
+	}
+
+	for _, table := range tables {
+		cpu, stepper := newTestCPU([]uint8{0x00})
+		cpu.ime = true
+		cpu.mmu.WriteByte(IEADDR, 0x1F)
+		cpu.mmu.RequestInterrupt(table.interrupt)
+
+		cycles := stepper()
+		if cpu.PC.word != table.vector {
+			t.Errorf("%s interrupt jumped to %X instead of %X", table.name, cpu.PC.word, table.vector)
+		}
+		if cycles != 20 {
+			t.Errorf("%s interrupt took %d cycles instead of 20", table.name, cycles)
+		}
+		if cpu.mmu.ReadByte(IFADDR)&0x1F != 0 {
+			t.Errorf("%s interrupt left IF at %X", table.name, cpu.mmu.ReadByte(IFADDR)&0x1F)
+		}
+		if cpu.ime {
+			t.Errorf("%s interrupt did not clear IME", table.name)
+		}
+		if cpu.mmu.ReadWord(cpu.SP.word) != 0xC000 {
+			t.Errorf("%s interrupt pushed %X instead of C000", table.name, cpu.mmu.ReadWord(cpu.SP.word))
+		}
+	}
+}
+
+func TestInterruptPriority(t *testing.T) {
+	cpu, stepper := newTestCPU([]uint8{0x00})
+	cpu.ime = true
+	cpu.mmu.WriteByte(IEADDR, 0x1C)
+	cpu.mmu.WriteByte(IFADDR, 0x1F)
+
+	stepper()
+	if cpu.PC.word != 0x50 {
+		t.Errorf("Highest priority enabled interrupt jumped to %X instead of 50", cpu.PC.word)
+	}
+	if cpu.mmu.ReadByte(IFADDR)&0x1F != 0x1B {
+		t.Errorf("IF is %X after servicing the timer interrupt, should be 1B", cpu.mmu.ReadByte(IFADDR)&0x1F)
+	}
+}
+
+func TestEIDelay(t *testing.T) {
+	// EI, INC A, INC A
+	cpu, stepper := newTestCPU([]uint8{0xFB, 0x3C, 0x3C})
+	cpu.mmu.WriteByte(IEADDR, 0x01)
+	cpu.mmu.RequestInterrupt(VBLANK)
+
+	stepper()
+	stepper()
+	if cpu.PC.word != 0xC002 {
+		t.Errorf("Interrupt serviced before the instruction after EI, PC is %X", cpu.PC.word)
+	}
+	stepper()
+	if cpu.PC.word != 0x40 {
+		t.Errorf("Interrupt not serviced after the instruction following EI, PC is %X", cpu.PC.word)
+	}
+
+	// EI, DI, INC A
+	cpu, stepper = newTestCPU([]uint8{0xFB, 0xF3, 0x3C})
+	cpu.mmu.WriteByte(IEADDR, 0x01)
+	cpu.mmu.RequestInterrupt(VBLANK)
+	for i := 0; i < 3; i++ {
+		stepper()
+	}
+	if cpu.PC.word != 0xC003 || cpu.ime {
+		t.Errorf("DI after EI did not cancel the pending IME, PC is %X", cpu.PC.word)
+	}
+}
+
+func TestRETI(t *testing.T) {
+	cpu, stepper := newTestCPU([]uint8{0xD9})
+	cpu.PushStack(0x1234)
+
+	stepper()
+	if cpu.PC.word != 0x1234 || !cpu.ime {
+		t.Errorf("RETI returned to %X with IME %v, should be 1234 and true", cpu.PC.word, cpu.ime)
+	}
+}
+
+func TestHalt(t *testing.T) {
+	// HALT, INC A
+	cpu, stepper := newTestCPU([]uint8{0x76, 0x3C})
+	cpu.ime = true
+	cpu.mmu.WriteByte(IEADDR, 0x04)
+
+	for i := 0; i < 10; i++ {
+		stepper()
+	}
+	if !cpu.halted || cpu.PC.word != 0xC001 {
+		t.Errorf("HALT did not wait for an interrupt, PC is %X", cpu.PC.word)
+	}
+
+	cpu.mmu.RequestInterrupt(TIMER)
+	stepper()
+	if cpu.halted || cpu.PC.word != 0x50 {
+		t.Errorf("HALT did not wake up into the timer handler, PC is %X", cpu.PC.word)
+	}
+
+	// With IME unset HALT wakes up and continues without servicing the interrupt.
+	cpu, stepper = newTestCPU([]uint8{0x76, 0x3C})
+	cpu.mmu.WriteByte(IEADDR, 0x04)
+	stepper()
+	stepper()
+	cpu.mmu.RequestInterrupt(TIMER)
+	stepper()
+	if cpu.PC.word != 0xC002 || cpu.mmu.ReadByte(IFADDR)&0x1F != 0x04 {
+		t.Errorf("HALT with IME unset jumped to %X with IF %X", cpu.PC.word, cpu.mmu.ReadByte(IFADDR)&0x1F)
+	}
+}
+
+func TestHaltBug(t *testing.T) {
+	// HALT, INC A, NOP
+	cpu, stepper := newTestCPU([]uint8{0x76, 0x3C, 0x00})
+	*cpu.AF.hi = 0
+	cpu.mmu.WriteByte(IEADDR, 0x04)
+	cpu.mmu.RequestInterrupt(TIMER)
+
+	stepper()
+	if cpu.halted {
+		t.Error("HALT with IME unset and a pending interrupt should not halt")
+	}
+	stepper()
+	stepper()
+	if *cpu.AF.hi != 2 || cpu.PC.word != 0xC002 {
+		t.Errorf("HALT bug gave A = %d and PC = %X, should be 2 and C002", *cpu.AF.hi, cpu.PC.word)
+	}
+
+	// HALT, LD A,d8 reads its own opcode as the operand
+	cpu, stepper = newTestCPU([]uint8{0x76, 0x3E, 0x14})
+	cpu.mmu.WriteByte(IEADDR, 0x04)
+	cpu.mmu.RequestInterrupt(TIMER)
+	stepper()
+	stepper()
+	if *cpu.AF.hi != 0x3E || cpu.PC.word != 0xC002 {
+		t.Errorf("HALT bug gave A = %X and PC = %X, should be 3E and C002", *cpu.AF.hi, cpu.PC.word)
+	}
+}
+
+func TestStop(t *testing.T) {
+	cpu, stepper := newTestCPU([]uint8{0x10, 0x00, 0x3C})
+	*cpu.AF.hi = 0
+
+	for i := 0; i < 10; i++ {
+		stepper()
+	}
+	if !cpu.stopped || *cpu.AF.hi != 0 {
+		t.Error("STOP did not stop the CPU")
+	}
+
+	cpu.mmu.RequestInterrupt(JOYPAD)
+	stepper()
+	if cpu.stopped || *cpu.AF.hi != 1 {
+		t.Error("STOP did not wake up on a joypad press")
+	}
+}
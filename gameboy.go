@@ -1,22 +1,53 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"image/png"
+	"io"
 	"io/ioutil"
-	"strings"
+	"os"
 	"time"
 )
 
+// FRAMEDURATION is how long the GameBoy takes to draw a frame at normal speed, about 59.7 frames a second
+const FRAMEDURATION = 16750419 * time.Nanosecond
+
 // GameBoy is a wrapper for the hardware components.
 // It controls the timing and linkage between the components.
 type GameBoy struct {
-	cpu *CPU
-	mmu *MMU
-	lcd *LCD
-	apu *APU
+	cpu    *CPU
+	mmu    *MMU
+	lcd    *LCD
+	apu    *APU
+	timer  *Timer
+	joypad *Joypad
+
+	path      string
+	cartridge []byte
+	header    CartridgeHeader
+	// romChecksum is the CRC-32 of the ROM, which save states are checked against
+	romChecksum uint32
+	save        *SaveFile
+	bootROM     []uint8
+	// skipBoot starts the cartridge straight away, in the post-boot state of model, instead of running the boot ROM
+	skipBoot bool
+	model    string
+	// saveDir is the directory save files are kept in, or empty to keep them next to the ROM
+	saveDir string
+
+	// sampleRate is the audio output rate, or 0 for the default
+	sampleRate int
+	// fastForward runs frames as fast as possible instead of at the GameBoy's frame rate
+	fastForward bool
+	// speed multiplies the frame rate when not fast forwarding, 0 runs at normal speed
+	speed float64
+	// trace, if set, receives a line for each instruction the CPU executes
+	trace io.Writer
 
-	cartridge  []byte
-	interrupts map[uint16]uint8
+	// frameCycles counts the cycles run in the current frame
+	frameCycles uint64
 }
 
 // Reset creates new hardware, links the memory to the processors, and resets each component.
@@ -25,122 +56,297 @@ func (g *GameBoy) Reset() {
 	g.mmu = &(MMU{})
 	g.lcd = &(LCD{})
 	g.apu = &(APU{})
+	g.timer = &(Timer{})
+	g.joypad = &(Joypad{})
+	g.save = nil
+	g.frameCycles = 0
 
+	g.mmu.Reset()
 	g.cpu.Reset(g.mmu)
+	if g.bootROM != nil {
+		check(g.cpu.LoadBootROM(g.bootROM))
+	}
+	g.cpu.SetTrace(g.trace)
 	g.lcd.Reset(g.mmu)
 	g.apu.Reset(g.mmu)
-	g.mmu.Reset()
+	if g.sampleRate != 0 {
+		g.apu.mixer.Reset(g.sampleRate)
+	}
+	g.timer.Reset(g.mmu)
+	g.timer.OnFrameSequencer(g.apu.ClockFrameSequencer)
+	g.joypad.Reset(g.mmu)
+
+	if g.skipBoot {
+		g.SkipBoot()
+	}
 }
 
-// CheckCartridgeHeader checks and prints the cartridge header information,
-//including game title, memory type, and size.
-func (g *GameBoy) CheckCartridgeHeader() {
+// SetSkipBoot makes the GameBoy skip the boot ROM on each reset and start in the post-boot state of a hardware model.
+func (g *GameBoy) SetSkipBoot(model string) error {
+	if _, ok := BOOTSTATES[model]; !ok {
+		return fmt.Errorf("unknown model %q, expected one of %v", model, modelNames())
+	}
+	g.skipBoot = true
+	g.model = model
+	return nil
+}
 
-	// Game title in upper-case ASCII always here
-	titleBytes := g.mmu.memory[0x0134:0x0142]
+// SkipBoot puts the hardware in the state the boot ROM of the model leaves it in when it jumps to the cartridge.
+func (g *GameBoy) SkipBoot() {
+	state := BOOTSTATES[g.model]
+	g.cpu.SkipBoot(state, g.header.HeaderChecksum)
 
-	// Load information about the cartridge memory type etc.
-	// to make sure we can run it with this crappy emulator
-	memInfoBytes := g.mmu.memory[0x0147:0x014B]
-	switch memInfoBytes[0] {
-	case 0x0:
-		fmt.Println("Cartridge uses ROM only, good to go!")
-	default:
-		panic(fmt.Errorf("Cartridge uses ROM plus other stuff. Abort!\n(Cartridge type %X)", memInfoBytes[0]))
+	// The boot ROM clears VRAM and leaves the logo from the header on screen
+	for address := uint16(0x8000); address < 0xA000; address++ {
+		g.mmu.WriteByte(address, 0)
 	}
+	drawBootLogo(g.mmu, g.cartridge[0x0104:0x0134])
+	for _, v := range POSTBOOTIO {
+		g.mmu.WriteByte(v.address, v.value)
+	}
+	g.timer.SetDivider(uint16(state.DIV) << 8)
+}
 
-	romSizeString := ""
-	switch memInfoBytes[1] {
-	case 0x0:
-		romSizeString = "32 KB"
-	case 0x1:
-		romSizeString = "64 KB"
-	default:
-		panic(fmt.Errorf("cartridge ROM size %X not supported", memInfoBytes[1]))
+// Configure applies the options given on the command line and loads the boot ROM and the ROM.
+func (g *GameBoy) Configure(options Options) error {
+	g.speed = options.Speed
+	if options.Trace {
+		g.trace = os.Stdout
+	}
+	if options.SaveDir != "" {
+		if err := os.MkdirAll(options.SaveDir, 0755); err != nil {
+			return fmt.Errorf("couldn't create the save directory: %s", err)
+		}
+		g.saveDir = options.SaveDir
+	}
+	if options.SkipBoot {
+		if err := g.SetSkipBoot(options.Model); err != nil {
+			return err
+		}
+	} else if err := g.LoadBootROMFromFile(options.BootROMPath); err != nil {
+		return err
+	}
+	return g.LoadROMFromFile(options.ROMPath)
+}
 
+// LoadBootROMFromFile reads the boot ROM, which is run before the cartridge on each reset, from a file.
+func (g *GameBoy) LoadBootROMFromFile(path string) error {
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("boot ROM %s doesn't exist, pass the path of a DMG boot ROM dump with --bootrom or start without one using --skip-boot", path)
+	} else if err != nil {
+		return fmt.Errorf("couldn't read the boot ROM: %s", err)
 	}
-	fmt.Printf("ROM Size is %s.\n", romSizeString)
+	if len(dat) != BLSIZE {
+		return fmt.Errorf("%s isn't a DMG boot ROM, it is 0x%X bytes long instead of 0x%X", path, len(dat), BLSIZE)
+	}
+	g.bootROM = dat
+	return nil
+}
 
-	ramSizeString := ""
-	switch memInfoBytes[2] {
-	case 0x0:
-		ramSizeString = "No cartridge RAM."
-	default:
-		panic(fmt.Errorf("cartridge RAM not supported"))
+// LoadROMFromFile loads a binary GameBoy data file from a filepath string, and its save file if it has one.
+func (g *GameBoy) LoadROMFromFile(path string) error {
+	Logf(LOGINFO, "Loading %s.", path)
+	dat, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("ROM %s doesn't exist", path)
+	} else if err != nil {
+		return fmt.Errorf("couldn't read the ROM: %s", err)
 	}
-	fmt.Printf("%s\n", ramSizeString)
+	Logf(LOGDEBUG, "Loaded 0x%X bytes of data.", len(dat))
 
-	destination := "Japanese"
-	if memInfoBytes[3] == 1 {
-		destination = "non-Japanese"
+	header, err := ParseCartridgeHeader(dat)
+	if err != nil {
+		return fmt.Errorf("%s isn't a GameBoy ROM: %s", path, err)
+	}
+	if err := header.Verify(); err != nil {
+		Logf(LOGWARN, "Warning: %s", err)
+	}
+	cartridge, err := NewCartridge(dat)
+	if err != nil {
+		return fmt.Errorf("can't play %s: %s", path, err)
 	}
-	fmt.Printf("Cartridge is for %s destination.\n", destination)
 
-	fmt.Print("\nNow playing ")
-	for _, v := range titleBytes {
-		if v != 0x0 {
-			fmt.Printf("%c", v)
+	g.path = path
+	g.cartridge = dat
+	g.header = header
+	g.romChecksum = crc32.ChecksumIEEE(dat)
+
+	g.Reset()
+	g.mmu.LoadCartridge(cartridge)
+
+	if battery, ok := cartridge.(BatteryBacked); ok && HasBattery(g.header.CartridgeType) {
+		g.save = &SaveFile{path: SavePath(path, g.saveDir), cartridge: battery}
+		if err := g.save.Load(); err != nil {
+			return fmt.Errorf("couldn't load the save file: %s", err)
 		}
 	}
-	fmt.Print("!\n========================================\n")
+
+	Logf(LOGINFO, "Now playing %s\n========================================", g.header)
+	return nil
 }
 
-// LoadROMFromFile loads a binary GameBoy data file from a filepath string.
-// Panics if any file read errors occur.
-func (g *GameBoy) LoadROMFromFile(path string) {
-	pathSplit := strings.Split(path, ",")
-	fmt.Printf("Loading %s.\n", pathSplit[len(pathSplit)-1])
-	dat, err := ioutil.ReadFile(path)
-	check(err)
-	fmt.Printf("Loaded 0x%X bytes of data.\n", len(dat))
+// FlushSave writes the cartridge RAM to its save file if it has changed since it was last written.
+func (g *GameBoy) FlushSave() error {
+	if g.save == nil {
+		return nil
+	}
+	return g.save.Flush()
+}
 
-	g.cartridge = dat
+// SaveState returns a snapshot of the state of the whole machine, which LoadState can restore.
+func (g *GameBoy) SaveState() []uint8 {
+	s := NewStateWriter(g.romChecksum)
+	g.SerializeState(s)
+	return s.Bytes()
+}
 
-	g.Reset()
+// LoadState restores the machine to a snapshot made by SaveState of the same ROM.
+// If the snapshot can't be loaded the machine is left as it was.
+func (g *GameBoy) LoadState(data []uint8) error {
+	if g.cartridge == nil {
+		return errors.New("no ROM is loaded")
+	}
+	s, err := NewStateReader(data, g.romChecksum)
+	if err != nil {
+		return err
+	}
 
-	g.mmu.LoadCartridgeData(g.cartridge)
-	g.CheckCartridgeHeader()
+	before := g.SaveState()
+	g.SerializeState(s)
+	if err := s.Err(); err != nil {
+		restore, _ := NewStateReader(before, g.romChecksum)
+		g.SerializeState(restore)
+		return err
+	}
+	return nil
 }
 
-func (g *GameBoy) SetupInterrupts() {
-	g.interrupts = map[uint16]uint8{}
-	g.interrupts[0xFF50] = 0x00
+// SerializeState writes or reads the state of each component in turn.
+func (g *GameBoy) SerializeState(s *StateSerializer) {
+	g.cpu.SerializeState(s)
+	g.mmu.SerializeState(s)
+	g.lcd.SerializeState(s)
+	g.apu.SerializeState(s)
+	g.timer.SerializeState(s)
+	g.joypad.SerializeState(s)
+	g.mmu.cartridge.SerializeState(s)
+	s.Uint64(&g.frameCycles)
 }
 
-func (g *GameBoy) HandleInterrupts() {
-	if g.interrupts[0xFF50] == 0 && g.mmu.memory[0xFF50] == 1 {
-		fmt.Println("Writing cartridge header to $0000-$0100")
-		for byte := 0; byte < 0x100; byte++ {
-			g.mmu.memory[uint16(byte)] = g.cartridge[byte]
-		}
-		g.interrupts[0xFF50] = 1
+// SaveStateToSlot writes a save state to a numbered slot, and returns the path of its file.
+func (g *GameBoy) SaveStateToSlot(slot int) (string, error) {
+	path := StatePath(g.path, g.saveDir, slot)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, g.SaveState(), 0644); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmpPath, path)
+}
+
+// LoadStateFromSlot loads the save state in a numbered slot, and returns the path of its file.
+func (g *GameBoy) LoadStateFromSlot(slot int) (string, error) {
+	path := StatePath(g.path, g.saveDir, slot)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return path, fmt.Errorf("slot %d is empty", slot)
+	} else if err != nil {
+		return path, err
+	}
+	if err := g.LoadState(data); err != nil {
+		return path, fmt.Errorf("loading %s: %s", path, err)
+	}
+	return path, nil
+}
+
+// Frame returns the shades 0-3 of the last frame drawn by the LCD, row by row from the top left of the screen.
+func (g *GameBoy) Frame() [SCREENWIDTH * SCREENHEIGHT]uint8 {
+	return g.lcd.Frame()
+}
+
+// SetFastForward switches between running as fast as possible and running at normal speed.
+func (g *GameBoy) SetFastForward(on bool) {
+	g.fastForward = on
+}
+
+// SaveScreenshot writes the last frame in the colors of a palette to a PNG file next to the ROM and returns its path.
+func (g *GameBoy) SaveScreenshot(palette Palette) (string, error) {
+	path := ScreenshotPath(g.path, time.Now())
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, FrameImage(g.Frame(), palette)); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// SetSampleRate sets the rate of the audio output in samples per second.
+func (g *GameBoy) SetSampleRate(rate int) {
+	g.sampleRate = rate
+	g.apu.mixer.Reset(rate)
+}
+
+// AudioSamples moves the audio produced since it was last called into out, as interleaved left and right 16-bit
+// samples, and returns how many samples it moved. Audio not collected in time is dropped.
+func (g *GameBoy) AudioSamples(out []int16) int {
+	return g.apu.mixer.buffer.Read(out)
+}
+
+// Press holds down a button of the joypad.
+func (g *GameBoy) Press(button uint8) {
+	g.joypad.Press(button)
+}
+
+// Release lets go of a button of the joypad.
+func (g *GameBoy) Release(button uint8) {
+	g.joypad.Release(button)
+}
+
+// OnRumble sets a function which is called whenever the cartridge switches its rumble motor on or off.
+// It does nothing for cartridges without a rumble motor.
+func (g *GameBoy) OnRumble(handler func(on bool)) {
+	if cartridge, ok := g.mmu.cartridge.(*MBC5); ok && cartridge.hasRumble {
+		cartridge.SetRumbleHandler(handler)
 	}
 }
 
 // Start starts the GameBoy.
 func (g *GameBoy) Start() func() {
-	g.SetupInterrupts()
 	cpuStepper := g.cpu.Start()
 	lcdStepper := g.lcd.Start()
-	var cyclesPerFrame = uint64(69833)
-	var currentCycles = uint64(0)
+	var cyclesPerFrame = uint64(LINECYCLES * FRAMELINES)
 	start := time.Now()
-	frameDelay := 16750419 * time.Nanosecond // 59.7 Hz
+	frameDelay := FRAMEDURATION
+	if g.speed > 0 {
+		frameDelay = time.Duration(float64(FRAMEDURATION) / g.speed)
+	}
 
 	return func() {
-		for currentCycles < cyclesPerFrame {
-			currentCycles += cpuStepper()
+		for g.frameCycles < cyclesPerFrame {
+			cycles := cpuStepper()
+			g.mmu.Step(cycles)
+			g.timer.Step(cycles)
+			g.apu.Step(cycles)
+			lcdStepper(cycles)
+			g.frameCycles += cycles
+		}
+		g.frameCycles -= cyclesPerFrame
+
+		if g.save != nil {
+			if err := g.save.Tick(); err != nil {
+				Logf(LOGERROR, "Failed to write save file: %s", err)
+			}
 		}
-		lcdStepper()
-		currentCycles = 0
 
 		elapsedTime := time.Now().Sub(start)
-		if elapsedTime < frameDelay {
+		if elapsedTime < frameDelay && !g.fastForward {
 			time.Sleep(frameDelay - elapsedTime)
 		}
 
-		g.HandleInterrupts()
-
 		start = time.Now()
 	}
 }
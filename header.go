@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// NINTENDOLOGO is the bitmap at 0x0104-0x0133 of every licensed cartridge, which the boot ROM checks before
+// starting the game.
+var NINTENDOLOGO = []uint8{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
+// cartridgeTypeNames maps the cartridge type byte at 0x0147 to a description of the cartridge hardware.
+var cartridgeTypeNames = map[uint8]string{
+	0x00: "ROM ONLY",
+	0x01: "MBC1",
+	0x02: "MBC1+RAM",
+	0x03: "MBC1+RAM+BATTERY",
+	0x05: "MBC2",
+	0x06: "MBC2+BATTERY",
+	0x08: "ROM+RAM",
+	0x09: "ROM+RAM+BATTERY",
+	0x0B: "MMM01",
+	0x0C: "MMM01+RAM",
+	0x0D: "MMM01+RAM+BATTERY",
+	0x0F: "MBC3+TIMER+BATTERY",
+	0x10: "MBC3+TIMER+RAM+BATTERY",
+	0x11: "MBC3",
+	0x12: "MBC3+RAM",
+	0x13: "MBC3+RAM+BATTERY",
+	0x19: "MBC5",
+	0x1A: "MBC5+RAM",
+	0x1B: "MBC5+RAM+BATTERY",
+	0x1C: "MBC5+RUMBLE",
+	0x1D: "MBC5+RUMBLE+RAM",
+	0x1E: "MBC5+RUMBLE+RAM+BATTERY",
+	0x20: "MBC6",
+	0x22: "MBC7+SENSOR+RUMBLE+RAM+BATTERY",
+	0xFC: "POCKET CAMERA",
+	0xFD: "BANDAI TAMA5",
+	0xFE: "HuC3",
+	0xFF: "HuC1+RAM+BATTERY",
+}
+
+// CartridgeHeader is the information stored in 0x0100-0x014F of a cartridge ROM.
+type CartridgeHeader struct {
+	Title string
+	// ManufacturerCode is only present on newer cartridges, in place of the end of the title.
+	ManufacturerCode string
+	CGBFlag          uint8
+	SGBFlag          uint8
+	// OldLicensee is the licensee code at 0x014B. A value of 0x33 means NewLicensee is used instead.
+	OldLicensee   uint8
+	NewLicensee   string
+	CartridgeType uint8
+	ROMSize       int
+	RAMSize       int
+	Destination   uint8
+	Version       uint8
+
+	HeaderChecksum uint8
+	GlobalChecksum uint16
+
+	// Results of checking the header and global checksums and the Nintendo logo against the ROM
+	HeaderChecksumValid bool
+	GlobalChecksumValid bool
+	LogoValid           bool
+}
+
+// ParseCartridgeHeader reads the header from ROM data.
+// An error is returned if the ROM is too short to hold a header or the ROM or RAM size codes are invalid.
+// Checksum and logo mismatches are not errors, they are reported by Verify.
+func ParseCartridgeHeader(data []uint8) (CartridgeHeader, error) {
+	var h CartridgeHeader
+	if len(data) < 0x150 {
+		return h, fmt.Errorf("ROM is only 0x%X bytes long, too short to contain a cartridge header", len(data))
+	}
+
+	h.CGBFlag = data[0x0143]
+	if h.CGBFlag&0x80 != 0 {
+		h.Title = headerString(data[0x0134:0x013F])
+		h.ManufacturerCode = headerString(data[0x013F:0x0143])
+	} else {
+		h.Title = headerString(data[0x0134:0x0144])
+	}
+	h.NewLicensee = headerString(data[0x0144:0x0146])
+	h.SGBFlag = data[0x0146]
+	h.CartridgeType = data[0x0147]
+	h.Destination = data[0x014A]
+	h.OldLicensee = data[0x014B]
+	h.Version = data[0x014C]
+	h.HeaderChecksum = data[0x014D]
+	h.GlobalChecksum = uint16(data[0x014E])<<8 | uint16(data[0x014F])
+
+	var err error
+	if h.ROMSize, err = ROMSize(data[0x0148]); err != nil {
+		return h, err
+	}
+	if h.RAMSize, err = RAMSize(data[0x0149]); err != nil {
+		return h, err
+	}
+
+	h.LogoValid = bytes.Equal(data[0x0104:0x0134], NINTENDOLOGO)
+
+	headerSum := uint8(0)
+	for _, v := range data[0x0134:0x014D] {
+		headerSum = headerSum - v - 1
+	}
+	h.HeaderChecksumValid = headerSum == h.HeaderChecksum
+
+	globalSum := uint16(0)
+	for i, v := range data {
+		if i != 0x014E && i != 0x014F {
+			globalSum += uint16(v)
+		}
+	}
+	h.GlobalChecksumValid = globalSum == h.GlobalChecksum
+
+	return h, nil
+}
+
+// headerString converts a zero-padded ASCII field of the header to a string.
+func headerString(field []uint8) string {
+	if end := bytes.IndexByte(field, 0); end >= 0 {
+		field = field[:end]
+	}
+	return strings.TrimSpace(string(field))
+}
+
+// ROMSize returns the size in bytes of the cartridge ROM for the ROM size code at 0x0148 in the header.
+func ROMSize(code uint8) (int, error) {
+	switch {
+	case code <= 0x08:
+		// 32 KB shifted left by the size code
+		return 0x8000 << code, nil
+	case code == 0x52:
+		return 72 * ROMBANKSIZE, nil
+	case code == 0x53:
+		return 80 * ROMBANKSIZE, nil
+	case code == 0x54:
+		return 96 * ROMBANKSIZE, nil
+	default:
+		return 0, fmt.Errorf("cartridge ROM size %X not supported", code)
+	}
+}
+
+// Verify returns an error describing any failed checks of the Nintendo logo and the header and global checksums.
+// Real hardware refuses to boot with a bad logo or header checksum, but ignores the global checksum.
+func (h CartridgeHeader) Verify() error {
+	failures := []string{}
+	if !h.LogoValid {
+		failures = append(failures, "Nintendo logo does not match")
+	}
+	if !h.HeaderChecksumValid {
+		failures = append(failures, fmt.Sprintf("header checksum %02X is wrong", h.HeaderChecksum))
+	}
+	if !h.GlobalChecksumValid {
+		failures = append(failures, fmt.Sprintf("global checksum %04X is wrong", h.GlobalChecksum))
+	}
+	if len(failures) != 0 {
+		return fmt.Errorf("invalid cartridge header: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// TypeName returns a description of the cartridge hardware.
+func (h CartridgeHeader) TypeName() string {
+	if name, ok := cartridgeTypeNames[h.CartridgeType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown type %02X", h.CartridgeType)
+}
+
+// Licensee returns the licensee code, using the new two character code if the old code says to.
+func (h CartridgeHeader) Licensee() string {
+	if h.OldLicensee == 0x33 {
+		return h.NewLicensee
+	}
+	return fmt.Sprintf("%02X", h.OldLicensee)
+}
+
+// String returns a summary of the header for printing when a ROM is loaded.
+func (h CartridgeHeader) String() string {
+	destination := "Japanese"
+	if h.Destination == 1 {
+		destination = "non-Japanese"
+	}
+
+	ram := "no RAM"
+	if h.RAMSize != 0 {
+		ram = fmt.Sprintf("%d KB RAM", h.RAMSize/1024)
+	}
+
+	return fmt.Sprintf("%s (%s, %d KB ROM, %s, version %d, licensee %s, %s destination)",
+		h.Title, h.TypeName(), h.ROMSize/1024, ram, h.Version, h.Licensee(), destination)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCartridgeHeader(t *testing.T) {
+	h, err := ParseCartridgeHeader(newHeaderROM("TETRIS", 0x00))
+	if err != nil {
+		t.Fatalf("Valid header gave error %v", err)
+	}
+	if h.Title != "TETRIS" || h.ManufacturerCode != "" {
+		t.Errorf("Title was %q, manufacturer %q, want TETRIS", h.Title, h.ManufacturerCode)
+	}
+	if h.TypeName() != "MBC1+RAM+BATTERY" || h.ROMSize != 0x8000 || h.RAMSize != 0x2000 {
+		t.Errorf("Type was %s, ROM %d, RAM %d", h.TypeName(), h.ROMSize, h.RAMSize)
+	}
+	if h.Licensee() != "01" {
+		t.Errorf("Licensee was %s, want 01", h.Licensee())
+	}
+	if err := h.Verify(); err != nil {
+		t.Errorf("Valid header failed verification: %v", err)
+	}
+
+	h, _ = ParseCartridgeHeader(newHeaderROM("POKEMON_SLVAAXE", 0x80))
+	if h.Title != "POKEMON_SLV" || h.ManufacturerCode != "AAXE" {
+		t.Errorf("CGB title was %q, manufacturer %q, want POKEMON_SLV, AAXE", h.Title, h.ManufacturerCode)
+	}
+}
+
+func TestCartridgeHeaderVerify(t *testing.T) {
+	tables := []struct {
+		corrupt                              func(rom []uint8)
+		logo, headerChecksum, globalChecksum bool
+	}{
+		{func(rom []uint8) {}, true, true, true},
+		{func(rom []uint8) { rom[0x0110]++ }, false, true, false},
+		{func(rom []uint8) { rom[0x014D]++ }, true, false, false},
+		{func(rom []uint8) { rom[0x014F]++ }, true, true, false},
+		{func(rom []uint8) { rom[0x4000]++ }, true, true, false},
+	}
+
+	for i, table := range tables {
+		rom := newHeaderROM("TETRIS", 0x00)
+		table.corrupt(rom)
+		h, err := ParseCartridgeHeader(rom)
+		if err != nil {
+			t.Fatalf("Case %d gave error %v", i, err)
+		}
+		if h.LogoValid != table.logo || h.HeaderChecksumValid != table.headerChecksum || h.GlobalChecksumValid != table.globalChecksum {
+			t.Errorf("Case %d: logo %v, header checksum %v, global checksum %v, want %v, %v, %v", i,
+				h.LogoValid, h.HeaderChecksumValid, h.GlobalChecksumValid, table.logo, table.headerChecksum, table.globalChecksum)
+		}
+		if (h.Verify() == nil) != (table.logo && table.headerChecksum && table.globalChecksum) {
+			t.Errorf("Case %d: Verify returned %v", i, h.Verify())
+		}
+	}
+}
+
+func TestCartridgeHeaderSizes(t *testing.T) {
+	tables := []struct {
+		code uint8
+		size int
+		ok   bool
+	}{
+		{0x00, 0x8000, true},
+		{0x05, 0x100000, true},
+		{0x08, 0x800000, true},
+		{0x52, 72 * ROMBANKSIZE, true},
+		{0x09, 0, false},
+		{0x55, 0, false},
+	}
+
+	for _, table := range tables {
+		size, err := ROMSize(table.code)
+		if (err == nil) != table.ok || size != table.size {
+			t.Errorf("ROM size code %02X gave %d, %v, want %d", table.code, size, err, table.size)
+		}
+	}
+
+	rom := newHeaderROM("TETRIS", 0x00)
+	rom[0x0148] = 0x20
+	if _, err := ParseCartridgeHeader(rom); err == nil {
+		t.Error("Invalid ROM size did not give an error")
+	}
+	if _, err := ParseCartridgeHeader(rom[:0x14F]); err == nil {
+		t.Error("Truncated ROM did not give an error")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+// HEADLESSFRAMES is how long the headless runner runs for unless told otherwise, one minute of GameBoy time
+const HEADLESSFRAMES = 3600
+
+// Headless runs a GameBoy without a window, sound or input, as fast as it can.
+// It lets tests, bots and batch tools run games on machines without a display.
+type Headless struct {
+	gb      *GameBoy
+	stepper func()
+	frames  int
+}
+
+// Start prepares to run a GameBoy which already has a ROM loaded.
+func (h *Headless) Start(gb *GameBoy) {
+	h.gb = gb
+	h.frames = 0
+	gb.SetFastForward(true)
+	h.stepper = gb.Start()
+}
+
+// RunFrames runs the GameBoy for a number of frames.
+func (h *Headless) RunFrames(frames int) {
+	for i := 0; i < frames; i++ {
+		h.Step()
+	}
+}
+
+// RunUntil runs the GameBoy until a condition, checked after every frame, is true or maxFrames frames have run.
+// It returns whether the condition was met.
+func (h *Headless) RunUntil(condition func(gb *GameBoy) bool, maxFrames int) bool {
+	for i := 0; i < maxFrames; i++ {
+		h.Step()
+		if condition(h.gb) {
+			return true
+		}
+	}
+	return false
+}
+
+// Step runs a single frame. The audio buffer is never read, so it just drops the oldest audio as it fills up.
+func (h *Headless) Step() {
+	h.stepper()
+	h.frames++
+}
+
+// Frames returns the number of frames run since Start.
+func (h *Headless) Frames() int {
+	return h.frames
+}
+
+// runHeadless runs a GameBoy with a ROM loaded for the number of frames in the options.
+func runHeadless(gb *GameBoy, options Options) {
+	frames := options.Frames
+	if frames == 0 {
+		frames = HEADLESSFRAMES
+	}
+
+	h := &(Headless{})
+	h.Start(gb)
+	h.RunFrames(frames)
+}
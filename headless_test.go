@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHeadless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	gb := newTestGameBoy(dir, STOREPROGRAM, "")
+	gb.mmu.WriteByte(0xC000, 0)
+
+	h := &(Headless{})
+	h.Start(gb)
+	h.RunFrames(2)
+	if h.Frames() != 2 {
+		t.Errorf("Ran %d frames, want 2", h.Frames())
+	}
+
+	// No boot ROM is loaded, so the zeroed bootloader runs NOPs up to the cartridge at 0x0100 within the first frame.
+	// The frame limit only stops a broken program from hanging the test.
+	done := h.RunUntil(func(gb *GameBoy) bool { return gb.mmu.ReadByte(0xC000) == 0x42 }, 600)
+	if !done {
+		t.Errorf("Program did not run within %d frames", h.Frames())
+	}
+	if h.RunUntil(func(gb *GameBoy) bool { return false }, 5) || h.Frames() > 607 {
+		t.Errorf("RunUntil did not stop after its frame limit, at %d frames", h.Frames())
+	}
+}
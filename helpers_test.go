@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+)
+
+// newTestMMU returns a reset MMU with no interrupts requested, for testing a component on its own.
+func newTestMMU() *MMU {
+	mmu := &(MMU{})
+	mmu.Reset()
+	mmu.WriteByte(IFADDR, 0)
+	return mmu
+}
+
+// newTestCPU returns a started CPU executing from WRAM with a program loaded at $C000.
+func newTestCPU(program []uint8) (*CPU, func() uint64) {
+	mmu := newTestMMU()
+	cpu := &(CPU{})
+	cpu.Reset(mmu)
+	stepper := cpu.Start()
+
+	for i, v := range program {
+		mmu.WriteByte(0xC000+uint16(i), v)
+	}
+	cpu.PC.word = 0xC000
+	cpu.SP.word = 0xDFFE
+	return cpu, stepper
+}
+
+// newTestLCD returns a reset LCD which is switched on.
+func newTestLCD() (*LCD, *MMU) {
+	mmu := newTestMMU()
+	lcd := &(LCD{})
+	lcd.Reset(mmu)
+	mmu.WriteByte(0xFF40, 0x80)
+	return lcd, mmu
+}
+
+// renderTestFrame steps the LCD through a whole frame and returns it.
+func renderTestFrame(lcd *LCD) [SCREENWIDTH * SCREENHEIGHT]uint8 {
+	lcd.Step(LINECYCLES * FRAMELINES)
+	return lcd.Frame()
+}
+
+// writeTestTiles clears VRAM and writes solid tiles of each color.
+// Unsigned tiles 1-3 at 0x8010 have colors 1-3, and signed tiles 1-3 at 0x9010 have colors 3-1.
+func writeTestTiles(mmu *MMU) {
+	for i := uint16(0x8000); i < 0xA000; i++ {
+		mmu.WriteByte(i, 0)
+	}
+	for color := uint16(1); color < 4; color++ {
+		for row := uint16(0); row < 8; row++ {
+			mmu.WriteByte(0x8000+color*16+2*row, uint8(0xFF*(color&1)))
+			mmu.WriteByte(0x8000+color*16+2*row+1, uint8(0xFF*(color>>1)))
+			mmu.WriteByte(0x9000+color*16+2*row, uint8(0xFF*((4-color)&1)))
+			mmu.WriteByte(0x9000+color*16+2*row+1, uint8(0xFF*((4-color)>>1)))
+		}
+	}
+}
+
+// newTestAPU returns a reset APU.
+func newTestAPU() (*APU, *MMU) {
+	mmu := newTestMMU()
+	apu := &(APU{})
+	apu.Reset(mmu)
+	return apu, mmu
+}
+
+// clockFrameSequencer runs the frame sequencer for a number of steps.
+func clockFrameSequencer(apu *APU, steps int) {
+	for i := 0; i < steps; i++ {
+		apu.ClockFrameSequencer()
+	}
+}
+
+// newTestTimer returns a reset timer.
+func newTestTimer() (*Timer, *MMU) {
+	mmu := newTestMMU()
+	timer := &(Timer{})
+	timer.Reset(mmu)
+	return timer, mmu
+}
+
+// newTestROM returns ROM data of a given number of banks with a header for a cartridge type and RAM size code.
+// The first byte of each bank is set to the bank number.
+func newTestROM(banks int, cartridgeType uint8, ramSize uint8) []uint8 {
+	rom := make([]uint8, banks*ROMBANKSIZE)
+	for bank := 0; bank < banks; bank++ {
+		rom[bank*ROMBANKSIZE] = uint8(bank)
+	}
+	rom[0x0147] = cartridgeType
+	rom[0x0149] = ramSize
+	return rom
+}
+
+// newHeaderROM returns a 32 KB ROM with a valid logo, title, and checksums.
+func newHeaderROM(title string, cgbFlag uint8) []uint8 {
+	rom := make([]uint8, 2*ROMBANKSIZE)
+	copy(rom[0x0104:], NINTENDOLOGO)
+	copy(rom[0x0134:], title)
+	rom[0x0143] = cgbFlag
+	rom[0x0147] = 0x03
+	rom[0x0149] = 0x02
+	rom[0x014A] = 0x01
+	rom[0x014B] = 0x33
+	copy(rom[0x0144:], "01")
+	rom[0x1234] = 0x56
+	fixChecksums(rom)
+	return rom
+}
+
+// fixChecksums recalculates the header and global checksums of a ROM.
+func fixChecksums(rom []uint8) {
+	headerSum := uint8(0)
+	for _, v := range rom[0x0134:0x014D] {
+		headerSum = headerSum - v - 1
+	}
+	rom[0x014D] = headerSum
+
+	globalSum := uint16(0)
+	for i, v := range rom {
+		if i != 0x014E && i != 0x014F {
+			globalSum += uint16(v)
+		}
+	}
+	rom[0x014E] = uint8(globalSum >> 8)
+	rom[0x014F] = uint8(globalSum)
+}
+
+// STOREPROGRAM stores $42 at $C000 and loops forever: LD A,$42; LD ($C000),A; JR -2
+var STOREPROGRAM = []uint8{0x3E, 0x42, 0xEA, 0x00, 0xC0, 0x18, 0xFE}
+
+// newTestGameBoy writes a ROM only cartridge running a program to test.gb in a directory and loads it. The entry
+// point jumps over the header to the program at $0150. With a model the boot ROM is skipped, otherwise an empty
+// boot ROM is mapped.
+func newTestGameBoy(dir string, program []uint8, model string) *GameBoy {
+	rom := newHeaderROM("TEST", 0x00)
+	rom[0x0147] = 0x00
+	rom[0x0149] = 0x00
+	// NOP; JP $0150
+	copy(rom[0x0100:], []uint8{0x00, 0xC3, 0x50, 0x01})
+	copy(rom[0x0150:], program)
+	fixChecksums(rom)
+	path := filepath.Join(dir, "test.gb")
+	check(ioutil.WriteFile(path, rom, 0644))
+
+	gb := &(GameBoy{})
+	if model != "" {
+		check(gb.SetSkipBoot(model))
+	}
+	check(gb.LoadROMFromFile(path))
+	return gb
+}
+
+// newRewindState returns a 64 KB snapshot which differs from the others in a few bytes.
+func newRewindState(i int) []uint8 {
+	state := make([]uint8, MEMORYSIZE)
+	for j := range state {
+		state[j] = uint8(j * 7)
+	}
+	state[i] = 0xFF
+	state[MEMORYSIZE-1-i] = uint8(i)
+	return state
+}
@@ -0,0 +1,26 @@
+package main
+
+// Interrupt constants are the bit number of the IF and IE registers corresponding to that interrupt.
+// Lower bits have higher priority.
+const (
+	VBLANK  = 0
+	LCDSTAT = 1
+	TIMER   = 2
+	SERIAL  = 3
+	JOYPAD  = 4
+)
+
+// IFADDR and IEADDR are the addresses of the interrupt flag and interrupt enable registers.
+const (
+	IFADDR = 0xFF0F
+	IEADDR = 0xFFFF
+)
+
+// INTERRUPTVECTOR is the address of the VBlank handler. Each following interrupt's handler is 8 bytes later.
+const INTERRUPTVECTOR = 0x40
+
+// RequestInterrupt sets the bit of an interrupt in the IF register.
+// The CPU services it once it is enabled in IE and IME is set.
+func (m *MMU) RequestInterrupt(interrupt uint8) {
+	m.WriteByte(IFADDR, m.ReadByte(IFADDR)|BitVal(interrupt))
+}
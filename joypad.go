@@ -0,0 +1,80 @@
+package main
+
+// Buttons of the joypad. The direction buttons are the low nibble of P1 when directions are selected, and the
+// action buttons are the low nibble when actions are selected.
+const (
+	BUTTONRIGHT  = 0
+	BUTTONLEFT   = 1
+	BUTTONUP     = 2
+	BUTTONDOWN   = 3
+	BUTTONA      = 4
+	BUTTONB      = 5
+	BUTTONSELECT = 6
+	BUTTONSTART  = 7
+)
+
+// Joypad backs the P1 register at 0xFF00.
+// The game selects the direction buttons by clearing bit 4 and the action buttons by clearing bit 5, then reads the
+// selected buttons from the low nibble, where a pressed button reads as 0.
+type Joypad struct {
+	mmu *MMU
+
+	// pressed has the bit of each button held down set
+	pressed uint8
+	// selection holds bits 4 and 5 of P1
+	selection uint8
+}
+
+// Reset releases all buttons and registers P1 with the MMU.
+func (j *Joypad) Reset(m *MMU) {
+	j.mmu = m
+	j.pressed = 0
+	j.selection = 0x30
+
+	m.RegisterIO(0xFF00, j.ReadP1, func(value uint8) {
+		j.update(func() { j.selection = value & 0x30 })
+	})
+}
+
+// ReadP1 returns the value of P1, with the lines of pressed buttons in the selected groups pulled low.
+func (j *Joypad) ReadP1() uint8 {
+	return j.selection | j.Lines()
+}
+
+// Lines returns the low nibble of P1.
+func (j *Joypad) Lines() uint8 {
+	lines := uint8(0x0F)
+	if !CheckBit(&j.selection, 4) {
+		lines &^= j.pressed & 0x0F
+	}
+	if !CheckBit(&j.selection, 5) {
+		lines &^= j.pressed >> 4
+	}
+	return lines
+}
+
+// Press holds a button down.
+func (j *Joypad) Press(button uint8) {
+	j.update(func() { j.pressed |= BitVal(button) })
+}
+
+// Release lets a button go.
+func (j *Joypad) Release(button uint8) {
+	j.update(func() { j.pressed &^= BitVal(button) })
+}
+
+// SerializeState writes or reads the selected button groups.
+// The buttons held down come from the player, not the state, so they aren't restored.
+func (j *Joypad) SerializeState(s *StateSerializer) {
+	s.Uint8(&j.selection)
+}
+
+// update makes a change to the joypad state and requests the joypad interrupt if any line of P1 fell from 1 to 0.
+// This happens when a selected button is pressed, or when a group with a button held is selected.
+func (j *Joypad) update(change func()) {
+	before := j.Lines()
+	change()
+	if before&^j.Lines() != 0 {
+		j.mmu.RequestInterrupt(JOYPAD)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestJoypad(t *testing.T) {
+	tables := []struct {
+		pressed   []uint8
+		selection uint8
+		p1        uint8
+	}{
+		{[]uint8{}, 0x30, 0xFF},
+		{[]uint8{}, 0x20, 0xEF},
+		{[]uint8{BUTTONRIGHT, BUTTONUP}, 0x20, 0xEA},
+		{[]uint8{BUTTONRIGHT, BUTTONUP}, 0x10, 0xDF},
+		{[]uint8{BUTTONA, BUTTONSTART}, 0x10, 0xD6},
+		{[]uint8{BUTTONA, BUTTONSTART}, 0x20, 0xEF},
+		{[]uint8{BUTTONDOWN, BUTTONB}, 0x00, 0xC5},
+		{[]uint8{BUTTONDOWN, BUTTONB}, 0x30, 0xFF},
+	}
+
+	for _, table := range tables {
+		mmu := &(MMU{})
+		joypad := &(Joypad{})
+		joypad.Reset(mmu)
+		for _, button := range table.pressed {
+			joypad.Press(button)
+		}
+		mmu.WriteByte(0xFF00, table.selection)
+		if p1 := mmu.ReadByte(0xFF00); p1 != table.p1 {
+			t.Errorf("Buttons %v with selection %X read P1 as %X, want %X", table.pressed, table.selection, p1, table.p1)
+		}
+	}
+}
+
+func TestJoypadInterrupt(t *testing.T) {
+	mmu := &(MMU{})
+	joypad := &(Joypad{})
+	joypad.Reset(mmu)
+	irq := func() bool {
+		requested := mmu.ReadByte(IFADDR)&BitVal(JOYPAD) != 0
+		mmu.WriteByte(IFADDR, 0)
+		return requested
+	}
+
+	mmu.WriteByte(0xFF00, 0x20)
+	joypad.Press(BUTTONA)
+	if irq() {
+		t.Error("Pressing an unselected button requested an interrupt")
+	}
+	joypad.Press(BUTTONLEFT)
+	if !irq() {
+		t.Error("Pressing a selected button did not request an interrupt")
+	}
+	joypad.Release(BUTTONLEFT)
+	if irq() {
+		t.Error("Releasing a button requested an interrupt")
+	}
+	mmu.WriteByte(0xFF00, 0x10)
+	if !irq() {
+		t.Error("Selecting a group with a button held did not request an interrupt")
+	}
+	joypad.Release(BUTTONA)
+	joypad.Press(BUTTONA)
+	if !irq() {
+		t.Error("Pressing a selected button again did not request an interrupt")
+	}
+}
@@ -1,46 +1,444 @@
 package main
 
 import (
-	"fmt"
+	"image/color"
+	"sort"
 	"time"
 )
 
+// Define the pixel width and height of the GameBoy display
+const (
+	SCREENWIDTH  = 160
+	SCREENHEIGHT = 144
+)
+
+// Palette holds the color each of the four shades is displayed as, from lightest to darkest
+type Palette [4]color.RGBA
+
+// DEFAULTPALETTE is the name of the palette used unless another is picked
+const DEFAULTPALETTE = "gray"
+
+// PALETTES are the palettes which can be picked by name
+var PALETTES = map[string]Palette{
+	"gray":   {{255, 255, 255, 255}, {170, 170, 170, 255}, {80, 80, 80, 255}, {0, 0, 0, 255}},
+	"green":  {{155, 188, 15, 255}, {139, 172, 15, 255}, {48, 98, 48, 255}, {15, 56, 15, 255}},
+	"pocket": {{196, 207, 161, 255}, {139, 149, 109, 255}, {77, 83, 60, 255}, {31, 31, 31, 255}},
+}
+
+// OAMADDR is the start of object attribute memory, which holds 40 sprites of 4 bytes each
+const OAMADDR = 0xFE00
+
+// Limits on the number of sprites
+const (
+	SPRITECOUNT    = 40
+	SPRITESPERLINE = 10
+)
+
+// Sprite attribute flag bits
+const (
+	SPRITEPALETTE  = 4
+	SPRITEXFLIP    = 5
+	SPRITEYFLIP    = 6
+	SPRITEPRIORITY = 7
+)
+
+// PPU modes, as reported in the low two bits of STAT
+const (
+	MODEHBLANK = 0
+	MODEVBLANK = 1
+	MODEOAM    = 2
+	MODEDRAW   = 3
+)
+
+// Timings of a scanline in cycles, and the number of lines in a frame
+const (
+	LINECYCLES = 456
+	OAMCYCLES  = 80
+	DRAWCYCLES = 172
+	FRAMELINES = 154
+)
+
+// STAT interrupt source enable bits
+const (
+	STATHBLANK = 3
+	STATVBLANK = 4
+	STATOAM    = 5
+	STATLYC    = 6
+)
+
+// LCD is the picture processing unit.
+// It is stepped with the cycles taken by the CPU and walks each scanline through OAM scan, drawing and HBlank,
+// followed by ten lines of VBlank.
 type LCD struct {
 	mmu *MMU
+
+	lcdc uint8
+	stat uint8
+	ly   uint8
+	lyc  uint8
+
+	mode       uint8
+	lineCycles uint64
+	// statLine is the OR of all enabled STAT interrupt sources. The interrupt is only requested when it rises,
+	// so a source becoming active while another is already active does not request a second interrupt.
+	statLine bool
+	frames   uint64
+
+	// The window is drawn from line WY onwards, once LY has matched WY during the frame. windowLine counts the
+	// lines of the window drawn so far, so hiding the window for some lines does not skip any of its rows.
+	windowTriggered bool
+	windowLine      uint8
+
+	// framebuffer holds the shades of the frame being drawn, and frame the last completed frame
+	framebuffer [SCREENWIDTH * SCREENHEIGHT]uint8
+	frame       [SCREENWIDTH * SCREENHEIGHT]uint8
+	// bgColors holds the color indices of the background and window on the current line, before the palette,
+	// since sprites with the priority flag are only drawn over color 0
+	bgColors [SCREENWIDTH]uint8
 }
 
+// Sprite is an entry in OAM.
+type Sprite struct {
+	index uint8
+	y     uint8
+	x     uint8
+	tile  uint8
+	flags uint8
+}
+
+// Reset puts the LCD at the start of a frame and registers its I/O registers with the MMU.
 func (l *LCD) Reset(m *MMU) {
 	l.mmu = m
+	l.lcdc = 0
+	l.stat = 0
+	l.ly = 0
+	l.lyc = 0
+	l.mode = MODEOAM
+	l.lineCycles = 0
+	l.statLine = false
+	l.frames = 0
+	l.windowTriggered = false
+	l.windowLine = 0
+	l.framebuffer = [SCREENWIDTH * SCREENHEIGHT]uint8{}
+	l.frame = [SCREENWIDTH * SCREENHEIGHT]uint8{}
+
+	m.RegisterIO(0xFF40, func() uint8 { return l.lcdc }, l.WriteLCDC)
+	m.RegisterIO(0xFF41, l.ReadSTAT, func(value uint8) {
+		// Only the interrupt enable bits are writable
+		l.stat = value & 0x78
+		l.UpdateSTAT()
+	})
+	// LY is read-only to the CPU
+	m.RegisterIO(0xFF44, func() uint8 { return l.ly }, func(uint8) {})
+	m.RegisterIO(0xFF45, func() uint8 { return l.lyc }, func(value uint8) {
+		l.lyc = value
+		l.UpdateSTAT()
+	})
 }
 
-func (l *LCD) CheckInterrupts() {
+// Enabled returns whether the LCD is switched on by bit 7 of LCDC.
+func (l *LCD) Enabled() bool {
+	return CheckBit(&l.lcdc, 7)
+}
 
+// WriteLCDC sets the LCD control register.
+// Switching the LCD off resets LY to 0 and holds the PPU in HBlank until it is switched back on.
+func (l *LCD) WriteLCDC(value uint8) {
+	wasEnabled := l.Enabled()
+	l.lcdc = value
+	switch {
+	case wasEnabled && !l.Enabled():
+		l.ly = 0
+		l.lineCycles = 0
+		l.mode = MODEHBLANK
+		l.statLine = false
+		// The screen goes blank while the LCD is off
+		l.frame = [SCREENWIDTH * SCREENHEIGHT]uint8{}
+	case !wasEnabled && l.Enabled():
+		l.ly = 0
+		l.lineCycles = 0
+		l.windowTriggered = false
+		l.windowLine = 0
+		l.SetMode(MODEOAM)
+	}
 }
 
-func (l *LCD) VBlankOn() {
-	l.mmu.memory[0xFF0F] |= 1
+// ReadSTAT returns the STAT register with the current mode and LY=LYC coincidence flag.
+func (l *LCD) ReadSTAT() uint8 {
+	value := l.stat | l.mode
+	if l.Enabled() && l.ly == l.lyc {
+		value |= 0x04
+	}
+	return value
 }
 
-func (l *LCD) VBlankOff() {
-	l.mmu.memory[0xFF0F] &= 30
+// SetMode changes the PPU mode and updates the STAT interrupt line.
+func (l *LCD) SetMode(mode uint8) {
+	l.mode = mode
+	l.UpdateSTAT()
 }
 
-func (l *LCD) IncLY() {
-	ly := l.mmu.memory[0xFF44]
-	ly++
+// UpdateSTAT recalculates the STAT interrupt line and requests an interrupt if it has gone from low to high.
+func (l *LCD) UpdateSTAT() {
+	if !l.Enabled() {
+		return
+	}
+
+	line := (CheckBit(&l.stat, STATLYC) && l.ly == l.lyc) ||
+		(CheckBit(&l.stat, STATHBLANK) && l.mode == MODEHBLANK) ||
+		(CheckBit(&l.stat, STATVBLANK) && l.mode == MODEVBLANK) ||
+		(CheckBit(&l.stat, STATOAM) && l.mode == MODEOAM)
+
+	if line && !l.statLine {
+		l.mmu.RequestInterrupt(LCDSTAT)
+	}
+	l.statLine = line
+}
+
+// Step advances the PPU by a number of cycles.
+func (l *LCD) Step(cycles uint64) {
+	if !l.Enabled() {
+		return
+	}
+
+	l.lineCycles += cycles
+	for {
+		switch l.mode {
+		case MODEOAM:
+			if l.lineCycles < OAMCYCLES {
+				return
+			}
+			l.SetMode(MODEDRAW)
+		case MODEDRAW:
+			if l.lineCycles < OAMCYCLES+DRAWCYCLES {
+				return
+			}
+			l.RenderLine()
+			l.SetMode(MODEHBLANK)
+		case MODEHBLANK:
+			if l.lineCycles < LINECYCLES {
+				return
+			}
+			l.NextLine()
+			if l.ly == SCREENHEIGHT {
+				l.frame = l.framebuffer
+				l.mmu.RequestInterrupt(VBLANK)
+				l.SetMode(MODEVBLANK)
+			} else {
+				l.SetMode(MODEOAM)
+			}
+		case MODEVBLANK:
+			if l.lineCycles < LINECYCLES {
+				return
+			}
+			l.NextLine()
+			if l.ly == 0 {
+				l.frames++
+				l.windowTriggered = false
+				l.windowLine = 0
+				l.SetMode(MODEOAM)
+			} else {
+				l.UpdateSTAT()
+			}
+		}
+	}
+}
 
-	if ly == 154 {
-		ly = 0
+// NextLine moves LY on to the next scanline, wrapping at the end of the frame.
+func (l *LCD) NextLine() {
+	l.lineCycles -= LINECYCLES
+	l.ly++
+	if l.ly == FRAMELINES {
+		l.ly = 0
 	}
-	l.mmu.memory[0xFF44] = ly
+}
+
+// Frame returns the shades 0-3 of the last completed frame, row by row from the top left of the screen.
+func (l *LCD) Frame() [SCREENWIDTH * SCREENHEIGHT]uint8 {
+	return l.frame
+}
 
-	if ly > 143 {
-		l.VBlankOn()
+// RenderLine draws the background and window of the current scanline into the framebuffer.
+func (l *LCD) RenderLine() {
+	line := l.framebuffer[int(l.ly)*SCREENWIDTH : (int(l.ly)+1)*SCREENWIDTH]
+
+	if l.ly == l.mmu.ReadByte(0xFF4A) {
+		l.windowTriggered = true
+	}
+
+	// With the background disabled it is blank and the window is hidden too, but sprites are still drawn
+	if CheckBit(&l.lcdc, 0) {
+		l.RenderBackground(line)
+		l.RenderWindow(line)
 	} else {
-		l.VBlankOff()
+		for x := range line {
+			line[x] = 0
+			l.bgColors[x] = 0
+		}
+	}
+
+	if CheckBit(&l.lcdc, 1) {
+		l.RenderSprites(line)
 	}
 }
 
+// RenderBackground draws the scrolled background into a line of the framebuffer.
+func (l *LCD) RenderBackground(line []uint8) {
+	bgp := l.mmu.ReadByte(0xFF47)
+	scx := l.mmu.ReadByte(0xFF43)
+	y := l.ly + l.mmu.ReadByte(0xFF42)
+	tileMap := l.BGTileMap()
+
+	for x := range line {
+		// The background is 256x256 pixels, so the scrolled position wraps around
+		bgx := uint8(x) + scx
+		tile := l.mmu.memory[tileMap+uint16(y/8)*32+uint16(bgx/8)]
+		l.bgColors[x] = l.TilePixel(l.TileAddress(tile), bgx%8, y%8)
+		line[x] = PaletteShade(bgp, l.bgColors[x])
+	}
+}
+
+// RenderWindow draws the window over a line of the framebuffer, if it is enabled and visible on this line.
+// The window's left edge is at WX-7. WX values below 7 cut off the start of the window, and values above 166
+// put it entirely off the right of the screen.
+func (l *LCD) RenderWindow(line []uint8) {
+	wx := int(l.mmu.ReadByte(0xFF4B))
+	if !CheckBit(&l.lcdc, 5) || !l.windowTriggered || wx > SCREENWIDTH+6 {
+		return
+	}
+
+	bgp := l.mmu.ReadByte(0xFF47)
+	y := l.windowLine
+	tileMap := l.WindowTileMap()
+
+	for x := range line {
+		if x < wx-7 {
+			continue
+		}
+		windowX := uint8(x - (wx - 7))
+		tile := l.mmu.memory[tileMap+uint16(y/8)*32+uint16(windowX/8)]
+		l.bgColors[x] = l.TilePixel(l.TileAddress(tile), windowX%8, y%8)
+		line[x] = PaletteShade(bgp, l.bgColors[x])
+	}
+
+	l.windowLine++
+}
+
+// SpriteHeight returns the height of sprites, 8 or 16 pixels depending on bit 2 of LCDC.
+func (l *LCD) SpriteHeight() uint8 {
+	if CheckBit(&l.lcdc, 2) {
+		return 16
+	}
+	return 8
+}
+
+// LineSprites returns the sprites on the current line, in the order they are drawn over each other.
+// Like OAM scan on hardware, only the first 10 sprites in OAM which overlap the line vertically are used, even if
+// some are off screen horizontally. The sprite with the lowest X is in front, with ties going to the earlier entry.
+func (l *LCD) LineSprites() []Sprite {
+	height := l.SpriteHeight()
+	sprites := make([]Sprite, 0, SPRITESPERLINE)
+	for i := uint8(0); i < SPRITECOUNT && len(sprites) < SPRITESPERLINE; i++ {
+		entry := l.mmu.memory[OAMADDR+4*uint16(i) : OAMADDR+4*uint16(i)+4]
+		// Sprite Y is the screen position plus 16
+		top := int(entry[0]) - 16
+		if int(l.ly) >= top && int(l.ly) < top+int(height) {
+			sprites = append(sprites, Sprite{index: i, y: entry[0], x: entry[1], tile: entry[2], flags: entry[3]})
+		}
+	}
+
+	sort.SliceStable(sprites, func(a, b int) bool { return sprites[a].x < sprites[b].x })
+	return sprites
+}
+
+// RenderSprites draws the sprites on the current line over the background and window.
+func (l *LCD) RenderSprites(line []uint8) {
+	height := l.SpriteHeight()
+	// drawn marks pixels covered by a higher priority sprite, which hides lower ones even when it is itself
+	// behind the background
+	drawn := [SCREENWIDTH]bool{}
+
+	for _, sprite := range l.LineSprites() {
+		row := l.ly + 16 - sprite.y
+		if CheckBit(&sprite.flags, SPRITEYFLIP) {
+			row = height - 1 - row
+		}
+		tile := sprite.tile
+		if height == 16 {
+			// The top half of a tall sprite is the even tile and the bottom half is the odd tile after it
+			tile = tile&0xFE + row/8
+		}
+		pixels := l.LoadTileFromAddress(0x8000 + uint16(tile)*16)
+
+		palette := l.mmu.ReadByte(0xFF48)
+		if CheckBit(&sprite.flags, SPRITEPALETTE) {
+			palette = l.mmu.ReadByte(0xFF49)
+		}
+
+		for col := uint8(0); col < 8; col++ {
+			// Sprite X is the screen position plus 8
+			x := int(sprite.x) - 8 + int(col)
+			if x < 0 || x >= SCREENWIDTH || drawn[x] {
+				continue
+			}
+
+			tileCol := col
+			if CheckBit(&sprite.flags, SPRITEXFLIP) {
+				tileCol = 7 - col
+			}
+			color := pixels[(row%8)*8+tileCol]
+			// Color 0 is transparent
+			if color == 0 {
+				continue
+			}
+
+			drawn[x] = true
+			if CheckBit(&sprite.flags, SPRITEPRIORITY) && l.bgColors[x] != 0 {
+				continue
+			}
+			line[x] = PaletteShade(palette, color)
+		}
+	}
+}
+
+// BGTileMap returns the address of the background tile map selected by bit 3 of LCDC.
+func (l *LCD) BGTileMap() uint16 {
+	if CheckBit(&l.lcdc, 3) {
+		return 0x9C00
+	}
+	return 0x9800
+}
+
+// WindowTileMap returns the address of the window tile map selected by bit 6 of LCDC.
+func (l *LCD) WindowTileMap() uint16 {
+	if CheckBit(&l.lcdc, 6) {
+		return 0x9C00
+	}
+	return 0x9800
+}
+
+// TileAddress returns the address of the data of a background or window tile.
+// With bit 4 of LCDC set tiles are numbered 0-255 from 0x8000, otherwise they are numbered -128-127 from 0x9000.
+func (l *LCD) TileAddress(tile uint8) uint16 {
+	if CheckBit(&l.lcdc, 4) {
+		return 0x8000 + uint16(tile)*16
+	}
+	return uint16(0x9000 + int(int8(tile))*16)
+}
+
+// TilePixel returns the color index 0-3 of a pixel in the tile at an address.
+func (l *LCD) TilePixel(address uint16, x uint8, y uint8) uint8 {
+	low := l.mmu.memory[address+2*uint16(y)]
+	high := l.mmu.memory[address+2*uint16(y)+1]
+	bit := 7 - x
+	return (low>>bit)&1 | ((high>>bit)&1)<<1
+}
+
+// PaletteShade maps a color index 0-3 to a shade through a palette register.
+func PaletteShade(palette uint8, color uint8) uint8 {
+	return (palette >> (2 * color)) & 0x03
+}
+
 // ConvertTileToPixels converts an array of tile data into an array of pixel values
 func (l *LCD) ConvertTileToPixels(tileData []uint8) [64]uint8 {
 	var row1, row2 uint8
@@ -69,17 +467,19 @@ func (l *LCD) LoadTileFromAddress(address uint16) [64]uint8 {
 	return l.ConvertTileToPixels(tileData)
 }
 
-// GetBGPixelArray returns an array of pixels which constitute the background of the GameBoy display.
+// GetBGPixelArray returns the color indices of the whole 256x256 pixel background, ignoring scrolling and palettes.
+// It is useful for dumping the background when debugging, the screen itself is drawn by RenderLine.
 func (l *LCD) GetBGPixelArray() [0x10000]uint8 {
 
-	//Tile map in 0x9800-0x9BFF
+	// Tile map in 0x9800-0x9BFF or 0x9C00-0x9FFF
 	bgPixels := [0x10000]uint8{}
 
 	// Loop over tilemap. Each index in the map points to an 8x8 tile.
-	for ix, v := range l.mmu.memory[0x9800:0x9C00] {
+	tileMap := l.BGTileMap()
+	for ix, v := range l.mmu.memory[tileMap : tileMap+0x400] {
 		ULX := (ix * 8) % 256
 		ULY := (ix / 32) * 2048
-		curTile := l.LoadTileFromAddress(0x8000 + uint16(16*v))
+		curTile := l.LoadTileFromAddress(l.TileAddress(v))
 		for j, px := range curTile {
 			pxid := (j/8)*256 + j%8
 			bgPixels[ULX+ULY+pxid] = px
@@ -89,18 +489,34 @@ func (l *LCD) GetBGPixelArray() [0x10000]uint8 {
 	return bgPixels
 }
 
-func (l *LCD) Start() func() {
+// SerializeState writes or reads the LCD registers, the mode timing, the window line and the frames.
+func (l *LCD) SerializeState(s *StateSerializer) {
+	s.Uint8(&l.lcdc)
+	s.Uint8(&l.stat)
+	s.Uint8(&l.ly)
+	s.Uint8(&l.lyc)
+	s.Uint8(&l.mode)
+	s.Uint64(&l.lineCycles)
+	s.Bool(&l.statLine)
+	s.Uint64(&l.frames)
+	s.Bool(&l.windowTriggered)
+	s.Uint8(&l.windowLine)
+	s.Raw(l.framebuffer[:])
+	s.Raw(l.frame[:])
+}
 
-	i := uint64(0)
-	start := time.Now()
+// Start returns a function which steps the LCD by a number of CPU cycles.
+func (l *LCD) Start() func(uint64) {
 
-	return func() {
-		i++
+	lastFrame := uint64(0)
+	start := time.Now()
 
-		l.IncLY()
+	return func(cycles uint64) {
+		l.Step(cycles)
 
-		if i%60 == 0 {
-			fmt.Println("60 screen updates in", time.Now().Sub(start))
+		if l.frames-lastFrame >= 60 {
+			Logf(LOGDEBUG, "60 screen updates in %s", time.Now().Sub(start))
+			lastFrame = l.frames
 			start = time.Now()
 		}
 	}
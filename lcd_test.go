@@ -20,3 +20,280 @@ func TestLCD(t *testing.T) {
 	}
 
 }
+
+func TestLCDModes(t *testing.T) {
+	lcd, mmu := newTestLCD()
+
+	tables := []struct {
+		cycles uint64
+		ly     uint8
+		mode   uint8
+	}{
+		{0, 0, MODEOAM},
+		{79, 0, MODEOAM},
+		{1, 0, MODEDRAW},
+		{172, 0, MODEHBLANK},
+		{203, 0, MODEHBLANK},
+		{1, 1, MODEOAM},
+		{LINECYCLES * 142, 143, MODEOAM},
+		{LINECYCLES, 144, MODEVBLANK},
+		{LINECYCLES * 9, 153, MODEVBLANK},
+		{LINECYCLES, 0, MODEOAM},
+	}
+
+	for i, table := range tables {
+		lcd.Step(table.cycles)
+		if ly := mmu.ReadByte(0xFF44); ly != table.ly {
+			t.Errorf("Step %d: LY was %d, want %d", i, ly, table.ly)
+		}
+		if mode := mmu.ReadByte(0xFF41) & 0x03; mode != table.mode {
+			t.Errorf("Step %d: mode was %d, want %d", i, mode, table.mode)
+		}
+	}
+
+	mmu.WriteByte(0xFF40, 0x00)
+	if mmu.ReadByte(0xFF44) != 0 || mmu.ReadByte(0xFF41)&0x03 != MODEHBLANK {
+		t.Errorf("Switching the LCD off left LY %d, STAT %02X", mmu.ReadByte(0xFF44), mmu.ReadByte(0xFF41))
+	}
+}
+
+func TestLCDVBlankInterrupt(t *testing.T) {
+	lcd, mmu := newTestLCD()
+
+	requests := 0
+	for i := 0; i < LINECYCLES*FRAMELINES*3/4; i++ {
+		lcd.Step(4)
+		if mmu.ReadByte(IFADDR)&BitVal(VBLANK) != 0 {
+			requests++
+			mmu.WriteByte(IFADDR, 0)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("VBlank was requested %d times in 3 frames, want 3", requests)
+	}
+}
+
+func TestLCDSTATInterrupt(t *testing.T) {
+	tables := []struct {
+		name   string
+		stat   uint8
+		lyc    uint8
+		cycles uint64
+		want   int
+	}{
+		{"LYC", 0x40, 10, LINECYCLES * FRAMELINES, 1},
+		{"HBlank", 0x08, 0, LINECYCLES * 10, 10},
+		{"OAM", 0x20, 0, LINECYCLES * 10, 10},
+		{"VBlank", 0x10, 0, LINECYCLES * FRAMELINES, 1},
+		// VBlank follows the HBlank of line 143 without the line falling, so it doesn't request another interrupt
+		{"HBlank and VBlank", 0x18, 0, LINECYCLES * FRAMELINES, SCREENHEIGHT},
+		// LY=LYC keeps the line high through the HBlank on line 5
+		{"LYC and HBlank", 0x48, 5, LINECYCLES * 6, 5},
+	}
+
+	for _, table := range tables {
+		lcd, mmu := newTestLCD()
+		mmu.WriteByte(0xFF45, table.lyc)
+		mmu.WriteByte(0xFF41, table.stat)
+		mmu.WriteByte(IFADDR, 0)
+
+		requests := 0
+		for i := uint64(0); i < table.cycles; i += 4 {
+			lcd.Step(4)
+			if mmu.ReadByte(IFADDR)&BitVal(LCDSTAT) != 0 {
+				requests++
+				mmu.WriteByte(IFADDR, 0)
+			}
+		}
+		if requests != table.want {
+			t.Errorf("%s: STAT was requested %d times, want %d", table.name, requests, table.want)
+		}
+	}
+
+	lcd, mmu := newTestLCD()
+	mmu.WriteByte(0xFF45, 0)
+	if mmu.ReadByte(0xFF41)&0x04 == 0 {
+		t.Error("Coincidence flag not set when LY=LYC")
+	}
+	lcd.Step(LINECYCLES)
+	if mmu.ReadByte(0xFF41)&0x04 != 0 {
+		t.Error("Coincidence flag set when LY!=LYC")
+	}
+}
+
+func TestLCDBackground(t *testing.T) {
+	tables := []struct {
+		name     string
+		lcdc     uint8
+		scx, scy uint8
+		bgp      uint8
+		// Expected shades at (0, 0), (8, 0), (0, 8) and (159, 143)
+		want [4]uint8
+	}{
+		{"Unsigned tiles", 0x91, 0, 0, 0xE4, [4]uint8{1, 2, 3, 3}},
+		{"Signed tiles", 0x81, 0, 0, 0xE4, [4]uint8{3, 2, 1, 1}},
+		{"Palette", 0x91, 0, 0, 0x1B, [4]uint8{2, 1, 0, 0}},
+		{"Scroll", 0x91, 8, 0, 0xE4, [4]uint8{2, 3, 1, 1}},
+		{"Scroll wraps", 0x91, 248, 248, 0xE4, [4]uint8{1, 3, 2, 3}},
+		{"Tile map", 0x99, 0, 0, 0xE4, [4]uint8{2, 2, 2, 2}},
+		{"Background off", 0x90, 0, 0, 0xE4, [4]uint8{0, 0, 0, 0}},
+	}
+
+	for _, table := range tables {
+		lcd, mmu := newTestLCD()
+		writeTestTiles(mmu)
+		// Map 0x9800 cycles through tiles 1, 2, 3 in reading order, map 0x9C00 is all tile 2
+		for i := uint16(0); i < 0x400; i++ {
+			mmu.WriteByte(0x9800+i, uint8(1+i%3))
+			mmu.WriteByte(0x9C00+i, 2)
+		}
+		mmu.WriteByte(0xFF43, table.scx)
+		mmu.WriteByte(0xFF42, table.scy)
+		mmu.WriteByte(0xFF47, table.bgp)
+		mmu.WriteByte(0xFF40, table.lcdc)
+
+		frame := renderTestFrame(lcd)
+		got := [4]uint8{frame[0], frame[8], frame[8*SCREENWIDTH], frame[SCREENWIDTH*SCREENHEIGHT-1]}
+		if got != table.want {
+			t.Errorf("%s: shades were %v, want %v", table.name, got, table.want)
+		}
+	}
+}
+
+func TestLCDWindow(t *testing.T) {
+	type pixel struct {
+		x, y  int
+		shade uint8
+	}
+
+	tables := []struct {
+		name   string
+		lcdc   uint8
+		wx, wy uint8
+		// hide disables the window on lines from hide[0] up to hide[1]
+		hide   [2]uint8
+		pixels []pixel
+	}{
+		{"Whole screen", 0xF1, 7, 0, [2]uint8{}, []pixel{{0, 0, 2}, {8, 0, 3}, {0, 8, 3}, {159, 143, 3}}},
+		{"Position", 0xF1, 87, 72, [2]uint8{}, []pixel{{79, 72, 1}, {80, 72, 2}, {88, 72, 3}, {80, 71, 1}, {80, 80, 3}}},
+		{"Disabled", 0xD1, 7, 0, [2]uint8{}, []pixel{{0, 0, 1}, {159, 143, 1}}},
+		{"Background off", 0xF0, 7, 0, [2]uint8{}, []pixel{{0, 0, 0}, {159, 143, 0}}},
+		{"Window map", 0xB1, 7, 0, [2]uint8{}, []pixel{{0, 0, 1}, {8, 0, 1}}},
+		{"WX below 7", 0xF1, 0, 0, [2]uint8{}, []pixel{{0, 0, 2}, {1, 0, 3}, {9, 0, 3}}},
+		{"WX 166", 0xF1, 166, 0, [2]uint8{}, []pixel{{158, 0, 1}, {159, 0, 2}}},
+		{"WX 167", 0xF1, 167, 0, [2]uint8{}, []pixel{{159, 0, 1}, {159, 143, 1}}},
+		{"WY off screen", 0xF1, 7, 144, [2]uint8{}, []pixel{{0, 143, 1}}},
+		// Hiding the window for 8 lines delays its rows rather than skipping them
+		{"Line counter", 0xF1, 7, 0, [2]uint8{4, 12}, []pixel{{0, 3, 2}, {0, 4, 1}, {0, 12, 2}, {0, 15, 2}, {0, 16, 3}}},
+	}
+
+	for _, table := range tables {
+		lcd, mmu := newTestLCD()
+		writeTestTiles(mmu)
+		// The background at 0x9800 is all tile 1. The window at 0x9C00 is tile 2 in its top left corner and tile 3
+		// everywhere else.
+		for i := uint16(0); i < 0x400; i++ {
+			mmu.WriteByte(0x9800+i, 1)
+			mmu.WriteByte(0x9C00+i, 3)
+		}
+		mmu.WriteByte(0x9C00, 2)
+		mmu.WriteByte(0xFF47, 0xE4)
+		mmu.WriteByte(0xFF4B, table.wx)
+		mmu.WriteByte(0xFF4A, table.wy)
+		mmu.WriteByte(0xFF40, table.lcdc)
+
+		for ly := uint8(0); ly < SCREENHEIGHT; ly++ {
+			if ly >= table.hide[0] && ly < table.hide[1] {
+				mmu.WriteByte(0xFF40, table.lcdc&^0x20)
+			} else {
+				mmu.WriteByte(0xFF40, table.lcdc)
+			}
+			lcd.Step(LINECYCLES)
+		}
+		lcd.Step(LINECYCLES * (FRAMELINES - SCREENHEIGHT))
+
+		frame := lcd.Frame()
+		for _, p := range table.pixels {
+			if shade := frame[p.y*SCREENWIDTH+p.x]; shade != p.shade {
+				t.Errorf("%s: pixel (%d, %d) was %d, want %d", table.name, p.x, p.y, shade, p.shade)
+			}
+		}
+	}
+}
+
+func TestLCDSprites(t *testing.T) {
+	type pixel struct {
+		x, y  int
+		shade uint8
+	}
+
+	tables := []struct {
+		name string
+		lcdc uint8
+		// OAM entries of Y, X, tile, flags, starting from the first
+		sprites [][4]uint8
+		pixels  []pixel
+	}{
+		{"Position", 0x93, [][4]uint8{{16, 8, 3, 0x00}}, []pixel{{0, 0, 3}, {7, 7, 3}, {8, 0, 0}, {0, 8, 0}}},
+		{"Offset", 0x93, [][4]uint8{{20, 30, 3, 0x00}}, []pixel{{21, 3, 0}, {22, 4, 3}, {29, 11, 3}, {30, 4, 0}}},
+		{"Off left edge", 0x93, [][4]uint8{{16, 4, 4, 0x00}}, []pixel{{0, 1, 2}, {3, 1, 2}, {4, 1, 0}}},
+		{"Transparent", 0x93, [][4]uint8{{16, 88, 0, 0x00}}, []pixel{{80, 0, 1}}},
+		{"Palette", 0x93, [][4]uint8{{16, 8, 1, 0x10}}, []pixel{{0, 0, 2}}},
+		{"X flip", 0x93, [][4]uint8{{16, 8, 4, 0x20}}, []pixel{{0, 1, 2}, {7, 1, 1}, {0, 0, 3}}},
+		{"Y flip", 0x93, [][4]uint8{{16, 8, 4, 0x40}}, []pixel{{0, 7, 3}, {0, 0, 1}, {7, 0, 2}}},
+		{"Tall", 0x97, [][4]uint8{{16, 8, 5, 0x00}}, []pixel{{0, 0, 3}, {0, 1, 1}, {0, 8, 1}, {7, 15, 1}, {0, 16, 0}}},
+		{"Tall Y flip", 0x97, [][4]uint8{{16, 8, 4, 0x40}}, []pixel{{0, 15, 3}, {0, 8, 1}, {0, 0, 1}}},
+		{"Behind background", 0x93, [][4]uint8{{16, 88, 3, 0x80}, {16, 8, 3, 0x80}}, []pixel{{80, 0, 1}, {0, 0, 3}}},
+		{"Lower X in front", 0x93, [][4]uint8{{16, 12, 1, 0x00}, {16, 8, 2, 0x00}}, []pixel{{3, 0, 2}, {4, 0, 2}, {8, 0, 1}}},
+		{"Earlier entry in front", 0x93, [][4]uint8{{16, 8, 1, 0x00}, {16, 8, 2, 0x00}}, []pixel{{0, 0, 1}}},
+		// The sprite in front is behind the background, but still hides the sprite behind it
+		{"Hidden front sprite", 0x93, [][4]uint8{{16, 88, 1, 0x80}, {16, 88, 2, 0x00}}, []pixel{{80, 0, 1}}},
+		{"Sprites off", 0x91, [][4]uint8{{16, 8, 3, 0x00}}, []pixel{{0, 0, 0}}},
+		{"Background off", 0x82, [][4]uint8{{16, 88, 3, 0x80}}, []pixel{{80, 0, 3}, {88, 0, 0}}},
+		{"Ten per line", 0x93, [][4]uint8{
+			{16, 8, 3, 0x00}, {16, 16, 3, 0x00}, {16, 24, 3, 0x00}, {16, 32, 3, 0x00}, {16, 40, 3, 0x00},
+			{16, 48, 3, 0x00}, {16, 56, 3, 0x00}, {16, 64, 3, 0x00}, {16, 0, 3, 0x00}, {16, 72, 3, 0x00},
+			{16, 80, 3, 0x00}, {24, 80, 3, 0x00},
+		}, []pixel{{64, 0, 3}, {72, 0, 0}, {72, 8, 3}}},
+	}
+
+	for _, table := range tables {
+		lcd, mmu := newTestLCD()
+		writeTestTiles(mmu)
+		// Tile 4 has a top row of color 3, with the rest color 1 on the left and color 2 on the right.
+		// Tile 5 is color 1.
+		for row := uint16(0); row < 8; row++ {
+			if row == 0 {
+				mmu.WriteByte(0x8040, 0xFF)
+				mmu.WriteByte(0x8041, 0xFF)
+			} else {
+				mmu.WriteByte(0x8040+2*row, 0xF0)
+				mmu.WriteByte(0x8041+2*row, 0x0F)
+			}
+			mmu.WriteByte(0x8050+2*row, 0xFF)
+		}
+		// The background is color 0 apart from a tile of color 1 at (80, 0)
+		mmu.WriteByte(0x980A, 1)
+		for i := uint16(0); i < 0xA0; i++ {
+			mmu.WriteByte(OAMADDR+i, 0)
+		}
+		for i, sprite := range table.sprites {
+			for j, v := range sprite {
+				mmu.WriteByte(OAMADDR+uint16(4*i+j), v)
+			}
+		}
+		mmu.WriteByte(0xFF47, 0xE4)
+		mmu.WriteByte(0xFF48, 0xE4)
+		mmu.WriteByte(0xFF49, 0x1B)
+		mmu.WriteByte(0xFF42, 0)
+		mmu.WriteByte(0xFF43, 0)
+		mmu.WriteByte(0xFF40, table.lcdc)
+
+		frame := renderTestFrame(lcd)
+		for _, p := range table.pixels {
+			if shade := frame[p.y*SCREENWIDTH+p.x]; shade != p.shade {
+				t.Errorf("%s: pixel (%d, %d) was %d, want %d", table.name, p.x, p.y, shade, p.shade)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Log levels, from the least to the most verbose
+const (
+	LOGERROR = iota
+	LOGWARN
+	LOGINFO
+	LOGDEBUG
+)
+
+// logLevelNames are the names of the log levels used on the command line, indexed by level
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+// logLevel is the most verbose level of message which is printed
+var logLevel = LOGINFO
+
+// SetLogLevel sets the most verbose level of message to print by its name.
+func SetLogLevel(name string) error {
+	for level, levelName := range logLevelNames {
+		if name == levelName {
+			logLevel = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, expected one of %v", name, logLevelNames)
+}
+
+// Logf prints a message if its level is enabled.
+// Errors and warnings go to stderr and everything else to stdout.
+func Logf(level int, format string, args ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	var w io.Writer = os.Stdout
+	if level <= LOGWARN {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format+"\n", args...)
+}
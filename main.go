@@ -1,5 +1,11 @@
 package main
 
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
 func check(e error) {
 	if e != nil {
 		panic(e)
@@ -9,9 +15,29 @@ func check(e error) {
 var done = make(chan int)
 
 func main() {
+	options, err := ParseOptions(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "goboy: %s\n", err)
+		os.Exit(2)
+	}
+	check(SetLogLevel(options.LogLevel))
+
 	// Create a new GameBoy, clear it, and read in cartridge data.
 	var gb = &(GameBoy{})
-	var sdl = &(SDL{})
-	sdl.Start(gb)
+	if err := gb.Configure(options); err != nil {
+		fmt.Fprintf(os.Stderr, "goboy: %s\n", err)
+		os.Exit(1)
+	}
 
+	if options.Headless {
+		runHeadless(gb, options)
+	} else {
+		runFrontend(gb, options)
+	}
+
+	if err := gb.FlushSave(); err != nil {
+		Logf(LOGERROR, "Failed to write save file: %s", err)
+	}
 }
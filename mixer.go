@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// CPUFREQUENCY is the number of cycles the GameBoy runs each second
+const CPUFREQUENCY = 4194304
+
+// AUDIOSAMPLERATE is the default rate of the audio output in samples per second
+const AUDIOSAMPLERATE = 48000
+
+// AUDIOBUFFERFRAMES is how many stereo samples the audio buffer holds, about a sixth of a second at 48 kHz
+const AUDIOBUFFERFRAMES = 8192
+
+// Mixer turns the APU's output into stereo samples at the output sample rate.
+// The output is averaged over the cycles making up each sample, which filters out frequencies too high for the
+// sample rate instead of letting them alias. Like the capacitors on the GameBoy's audio output, a high-pass filter
+// then removes any DC offset.
+type Mixer struct {
+	cyclesPerSample float64
+	// Running totals of the output, weighted by the cycles it lasted, since the last sample
+	cycles float64
+	left   float64
+	right  float64
+
+	// capacitors holds the charge of the left and right high-pass filters, and charge is the fraction of it kept
+	// from one sample to the next
+	capacitors [2]float64
+	charge     float64
+
+	buffer AudioBuffer
+}
+
+// Reset clears the mixer and sets its sample rate.
+func (m *Mixer) Reset(sampleRate int) {
+	m.cyclesPerSample = float64(CPUFREQUENCY) / float64(sampleRate)
+	m.cycles = 0
+	m.left = 0
+	m.right = 0
+	m.capacitors = [2]float64{}
+	// The hardware's capacitor keeps 0.999958 of its charge every cycle
+	m.charge = math.Pow(0.999958, m.cyclesPerSample)
+	m.buffer.Reset(AUDIOBUFFERFRAMES)
+}
+
+// Add mixes in the output of the APU, from -1 to 1 on each side, for a number of cycles.
+func (m *Mixer) Add(left float64, right float64, cycles uint64) {
+	remaining := float64(cycles)
+	for remaining > 0 {
+		// Split the cycles where they cross into the next sample
+		portion := math.Min(remaining, m.cyclesPerSample-m.cycles)
+		m.left += left * portion
+		m.right += right * portion
+		m.cycles += portion
+		remaining -= portion
+
+		if m.cycles >= m.cyclesPerSample {
+			m.buffer.Write(m.HighPass(0, m.left/m.cycles), m.HighPass(1, m.right/m.cycles))
+			m.cycles = 0
+			m.left = 0
+			m.right = 0
+		}
+	}
+}
+
+// HighPass filters a sample through the left (0) or right (1) capacitor, and converts it to 16 bits.
+func (m *Mixer) HighPass(side int, in float64) int16 {
+	out := in - m.capacitors[side]
+	m.capacitors[side] = in - out*m.charge
+	return int16(math.Max(-1, math.Min(1, out)) * math.MaxInt16)
+}
+
+// AudioBuffer is a ring buffer of interleaved left and right samples, passing audio from the emulation to the
+// frontend. When it is full the oldest samples are dropped.
+type AudioBuffer struct {
+	mutex   sync.Mutex
+	samples []int16
+	start   int
+	length  int
+}
+
+// Reset empties the buffer and sets how many stereo samples it holds.
+func (b *AudioBuffer) Reset(frames int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.samples = make([]int16, 2*frames)
+	b.start = 0
+	b.length = 0
+}
+
+// Write adds a stereo sample to the buffer.
+func (b *AudioBuffer) Write(left int16, right int16) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.length == len(b.samples) {
+		b.start = (b.start + 2) % len(b.samples)
+		b.length -= 2
+	}
+	end := (b.start + b.length) % len(b.samples)
+	b.samples[end] = left
+	b.samples[end+1] = right
+	b.length += 2
+}
+
+// Read moves samples from the buffer into out, left then right, and returns how many it moved.
+// Only whole stereo samples are moved.
+func (b *AudioBuffer) Read(out []int16) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := len(out) &^ 1
+	if n > b.length {
+		n = b.length
+	}
+	for i := 0; i < n; i++ {
+		out[i] = b.samples[(b.start+i)%len(b.samples)]
+	}
+	b.start = (b.start + n) % len(b.samples)
+	b.length -= n
+	return n
+}
+
+// Len returns the number of samples in the buffer, counting left and right separately.
+func (b *AudioBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.length
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAPUMix(t *testing.T) {
+	tables := []struct {
+		name        string
+		nr50, nr51  uint8
+		left, right float64
+	}{
+		{"Muted", 0x77, 0x00, 0, 0},
+		{"Left only", 0x77, 0x20, -0.25, 0},
+		{"Right only", 0x77, 0x02, 0, -0.25},
+		{"Both sides", 0x77, 0x22, -0.25, -0.25},
+		{"Volume", 0x30, 0x22, -0.125, -0.03125},
+		// Channel 1 is on with its output low, which its DAC turns into 1
+		{"Two channels", 0x77, 0x33, 0.0, 0.0},
+		{"Disabled DAC", 0x77, 0x88, 0, 0},
+	}
+
+	for _, table := range tables {
+		apu, mmu := newTestAPU()
+		mmu.WriteByte(0xFF24, table.nr50)
+		mmu.WriteByte(0xFF25, table.nr51)
+		// Channel 1 plays 0, channel 2 plays 15 with a 100% duty step, and channel 4 has its DAC off
+		mmu.WriteByte(0xFF12, 0xF0)
+		mmu.WriteByte(0xFF14, 0x80)
+		apu.square1.volume = 0
+		mmu.WriteByte(0xFF16, 0xC0)
+		mmu.WriteByte(0xFF17, 0xF0)
+		mmu.WriteByte(0xFF19, 0x80)
+		apu.square2.dutyStep = 1
+		mmu.WriteByte(0xFF21, 0x00)
+
+		left, right := apu.Mix()
+		if math.Abs(left-table.left) > 1e-9 || math.Abs(right-table.right) > 1e-9 {
+			t.Errorf("%s: mixed %v, %v, want %v, %v", table.name, left, right, table.left, table.right)
+		}
+	}
+}
+
+func TestAPUPower(t *testing.T) {
+	apu, mmu := newTestAPU()
+	mmu.WriteByte(0xFF24, 0x77)
+	mmu.WriteByte(0xFF25, 0xFF)
+	mmu.WriteByte(0xFF16, 0x80|0x3C)
+	mmu.WriteByte(0xFF17, 0xF0)
+	mmu.WriteByte(0xFF19, 0xC0)
+	mmu.WriteByte(0xFF30, 0x12)
+
+	if nr52 := mmu.ReadByte(0xFF26); nr52 != 0xF2 {
+		t.Errorf("NR52 read as %X with channel 2 playing, want F2", nr52)
+	}
+
+	mmu.WriteByte(0xFF26, 0x00)
+	if nr52 := mmu.ReadByte(0xFF26); nr52 != 0x70 {
+		t.Errorf("NR52 read as %X after power off, want 70", nr52)
+	}
+	for address := uint16(0xFF10); address < 0xFF26; address++ {
+		if read := mmu.ReadByte(address); read != ioReadMasks[address-0xFF00] {
+			t.Errorf("$%X read as %X after power off, want %X", address, read, ioReadMasks[address-0xFF00])
+		}
+	}
+	if mmu.ReadByte(0xFF30) != 0x12 || apu.square2.length != 4 {
+		t.Errorf("Power off changed wave RAM to %X and length to %d", mmu.ReadByte(0xFF30), apu.square2.length)
+	}
+
+	// Only lengths can be written while powered off
+	mmu.WriteByte(0xFF24, 0x77)
+	mmu.WriteByte(0xFF16, 0xC1)
+	if mmu.ReadByte(0xFF24) != 0 || apu.square2.duty != 0 || apu.square2.length != 63 {
+		t.Errorf("Writes while off set NR50 %X, duty %d, length %d", mmu.ReadByte(0xFF24), apu.square2.duty, apu.square2.length)
+	}
+
+	apu.frameStep = 5
+	mmu.WriteByte(0xFF26, 0x80)
+	mmu.WriteByte(0xFF24, 0x77)
+	if mmu.ReadByte(0xFF24) != 0x77 || apu.frameStep != 0 {
+		t.Errorf("After power on NR50 was %X and frame sequencer at step %d", mmu.ReadByte(0xFF24), apu.frameStep)
+	}
+}
+
+func TestMixerSampleRate(t *testing.T) {
+	tables := []struct {
+		rate int
+	}{
+		{44100},
+		{48000},
+	}
+
+	for _, table := range tables {
+		m := &(Mixer{})
+		m.Reset(table.rate)
+		// A tenth of a second, in machine cycles
+		for i := 0; i < CPUFREQUENCY/10/4; i++ {
+			m.Add(0, 0, 4)
+		}
+		if got := m.buffer.Len() / 2; got < table.rate/10-1 || got > table.rate/10+1 {
+			t.Errorf("%d Hz mixer made %d samples in 0.1s, want %d", table.rate, got, table.rate/10)
+		}
+	}
+}
+
+func TestMixerFilters(t *testing.T) {
+	m := &(Mixer{})
+	m.Reset(AUDIOSAMPLERATE)
+	out := make([]int16, 2)
+
+	// Just over one sample's worth of cycles, so each Add makes a sample
+	cycles := uint64(CPUFREQUENCY/AUDIOSAMPLERATE) + 1
+
+	// A square wave at the Nyquist frequency averages out, instead of aliasing
+	for i := 0; i < 100; i++ {
+		m.Add(0.5, 0.5, cycles/2)
+		m.Add(-0.5, -0.5, cycles/2)
+		m.buffer.Read(out)
+	}
+	if math.Abs(float64(out[0])) > 0.1*math.MaxInt16 {
+		t.Errorf("Square wave above the sample rate came out as %d", out[0])
+	}
+
+	// The high-pass filter lets a step through at first, then decays it back to 0
+	m.Reset(AUDIOSAMPLERATE)
+	m.Add(0.5, 0.5, cycles)
+	m.buffer.Read(out)
+	if out[0] < 16000 {
+		t.Errorf("Step came out as %d, want about 16383", out[0])
+	}
+	for i := 0; i < AUDIOSAMPLERATE; i++ {
+		m.Add(0.5, 0.5, cycles)
+		m.buffer.Read(out)
+	}
+	if out[0] > 100 || out[1] > 100 {
+		t.Errorf("DC offset remained as %d, %d after a second", out[0], out[1])
+	}
+}
+
+func TestAudioBuffer(t *testing.T) {
+	b := &(AudioBuffer{})
+	b.Reset(4)
+	for i := int16(0); i < 6; i++ {
+		b.Write(i, -i)
+	}
+
+	// The oldest two samples were dropped
+	out := make([]int16, 5)
+	if n := b.Read(out); n != 4 || out[0] != 2 || out[1] != -2 || out[2] != 3 {
+		t.Errorf("Read %d samples %v, want 4 starting 2, -2, 3", n, out[:n])
+	}
+	if n := b.Read(out); n != 4 || out[0] != 4 || out[3] != -5 {
+		t.Errorf("Read %d samples %v, want 4 starting 4 and ending -5", n, out[:n])
+	}
+	if n := b.Read(out); n != 0 {
+		t.Errorf("Read %d samples from an empty buffer", n)
+	}
+}
@@ -1,48 +1,236 @@
 package main
 
 import (
+	"errors"
 	"math/rand"
 )
 
 // MEMORYSIZE is fixed at pow(2, 16) bytes
 const MEMORYSIZE = 0x10000
 
-// MMU is a struct with a fixed-size memory and access functions
+// Length of an OAM DMA transfer in bytes, and the cycles taken to copy each byte
+const (
+	DMALENGTH     = 0xA0
+	DMABYTECYCLES = 4
+)
+
+// ioReadMasks holds the bits of each I/O register in 0xFF00-0xFF7F which are unused and always read as 1.
+// Unmapped registers read as 0xFF.
+var ioReadMasks = [0x80]uint8{
+	0xC0, 0x00, 0x7E, 0xFF, 0x00, 0x00, 0x00, 0xF8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xE0, // 0xFF00
+	0x80, 0x3F, 0x00, 0xFF, 0xBF, 0xFF, 0x3F, 0x00, 0xFF, 0xBF, 0x7F, 0xFF, 0x9F, 0xFF, 0xBF, 0xFF, // 0xFF10
+	0xFF, 0x00, 0x00, 0xBF, 0x00, 0x00, 0x70, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // 0xFF20
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // 0xFF30
+	0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, // 0xFF40
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // 0xFF50
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // 0xFF60
+	0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // 0xFF70
+}
+
+// MMU routes memory accesses by address to the cartridge, the internal RAM regions, and the I/O registers.
+// VRAM, WRAM, OAM, HRAM, IE and any I/O register without a handler are stored in memory.
 type MMU struct {
 	memory [MEMORYSIZE]uint8
+
+	cartridge      Cartridge
+	bootROM        []uint8
+	bootROMEnabled bool
+
+	// Handlers for I/O registers owned by other components, indexed by address - 0xFF00
+	ioReadHandlers  [0x80]func() uint8
+	ioWriteHandlers [0x80]func(uint8)
+
+	// OAM DMA transfer state. dmaCycles counts the cycles since the transfer started.
+	dmaActive bool
+	dmaSource uint16
+	dmaCycles uint64
 }
 
 // Reset initializes the memory of an MMU to random 8-bit integers.
 func (m *MMU) Reset() {
+	m.dmaActive = false
 	m.memory = [MEMORYSIZE]uint8{}
 	for i := range m.memory {
 		m.memory[i] = uint8(rand.Intn(0x100))
 	}
 }
 
-// LoadCartridgeData reads into memory the data from a passed cartridge.
-func (m *MMU) LoadCartridgeData(data []uint8) {
-	for i := 0x0100; i < 0x8000; i++ {
-		address := uint16(i)
-		m.WriteByte(address, data[i])
-	}
+// LoadCartridge maps a cartridge into the ROM and external RAM regions.
+func (m *MMU) LoadCartridge(cartridge Cartridge) {
+	m.cartridge = cartridge
+}
+
+// LoadBootROM maps the boot ROM over 0x0000-0x00FF until a non-zero value is written to 0xFF50.
+func (m *MMU) LoadBootROM(data []uint8) {
+	m.bootROM = data
+	m.bootROMEnabled = true
+}
+
+// RegisterIO gives ownership of an I/O register to another component.
+// Reads of the register call read and writes call write instead of accessing memory.
+// Either handler may be nil to keep the default behaviour for that direction.
+func (m *MMU) RegisterIO(address uint16, read func() uint8, write func(uint8)) {
+	m.ioReadHandlers[address-0xFF00] = read
+	m.ioWriteHandlers[address-0xFF00] = write
 }
 
 // ReadByte returns the byte of memory at a given address.
+// While an OAM DMA transfer is running the DMA owns the memory bus, so only the I/O registers, HRAM and IE can be read.
 func (m *MMU) ReadByte(address uint16) uint8 {
-	return m.memory[address]
+	if m.dmaActive && address < 0xFF00 {
+		return 0xFF
+	}
+	return m.ReadBus(address)
+}
+
+// ReadBus returns the byte of memory at a given address, regardless of any DMA transfer.
+func (m *MMU) ReadBus(address uint16) uint8 {
+	switch {
+	case address < 0x0100 && m.bootROMEnabled:
+		return m.bootROM[address]
+	case address < 0x8000:
+		// ROM
+		if m.cartridge == nil {
+			return 0xFF
+		}
+		return m.cartridge.ReadROM(address)
+	case address < 0xA000:
+		// VRAM
+		return m.memory[address]
+	case address < 0xC000:
+		// External RAM
+		if m.cartridge == nil {
+			return 0xFF
+		}
+		return m.cartridge.ReadRAM(address)
+	case address < 0xE000:
+		// WRAM
+		return m.memory[address]
+	case address < 0xFE00:
+		// Echo RAM mirrors 0xC000-0xDDFF
+		return m.memory[address-0x2000]
+	case address < 0xFEA0:
+		// OAM
+		return m.memory[address]
+	case address < 0xFF00:
+		// Unusable
+		return 0x00
+	case address < 0xFF80:
+		return m.ReadIO(address)
+	default:
+		// HRAM and IE
+		return m.memory[address]
+	}
 }
 
 // WriteByte writes a given byte to memory at a given address.
+// Writes outside the I/O registers, HRAM and IE are ignored while an OAM DMA transfer is running.
 func (m *MMU) WriteByte(address uint16, value uint8) {
+	if m.dmaActive && address < 0xFF00 {
+		return
+	}
+
+	switch {
+	case address < 0x8000:
+		// ROM is read-only, writes set the memory bank controller registers instead
+		if m.cartridge != nil {
+			m.cartridge.WriteROM(address, value)
+		}
+	case address < 0xA000:
+		m.memory[address] = value
+	case address < 0xC000:
+		if m.cartridge != nil {
+			m.cartridge.WriteRAM(address, value)
+		}
+	case address < 0xE000:
+		m.memory[address] = value
+	case address < 0xFE00:
+		m.memory[address-0x2000] = value
+	case address < 0xFEA0:
+		m.memory[address] = value
+	case address < 0xFF00:
+		// Unusable
+	case address < 0xFF80:
+		m.WriteIO(address, value)
+	default:
+		m.memory[address] = value
+	}
+}
+
+// ReadIO reads an I/O register in 0xFF00-0xFF7F, with its unused bits set to 1.
+func (m *MMU) ReadIO(address uint16) uint8 {
+	index := address - 0xFF00
+	if read := m.ioReadHandlers[index]; read != nil {
+		return read() | ioReadMasks[index]
+	}
+	return m.memory[address] | ioReadMasks[index]
+}
+
+// WriteIO writes an I/O register in 0xFF00-0xFF7F, handling the side effects of registers no component owns.
+func (m *MMU) WriteIO(address uint16, value uint8) {
+	if write := m.ioWriteHandlers[address-0xFF00]; write != nil {
+		write(value)
+		return
+	}
+
+	switch address {
+	case 0xFF04:
+		// Any write to DIV resets it
+		value = 0
+	case 0xFF46:
+		m.StartDMA(value)
+	case 0xFF50:
+		// Any non-zero write unmaps the boot ROM
+		if value != 0 {
+			m.bootROMEnabled = false
+		}
+	}
 	m.memory[address] = value
 }
 
+// StartDMA begins an OAM DMA transfer, which copies 0xA0 bytes from the page value*0x100 to OAM at 0xFE00.
+func (m *MMU) StartDMA(value uint8) {
+	m.dmaActive = true
+	m.dmaSource = uint16(value) << 8
+	m.dmaCycles = 0
+}
+
+// Step advances any OAM DMA transfer by a number of cycles, copying one byte every 4 cycles.
+func (m *MMU) Step(cycles uint64) {
+	if !m.dmaActive {
+		return
+	}
+
+	copied := m.dmaCycles / DMABYTECYCLES
+	m.dmaCycles += cycles
+	for ; copied < m.dmaCycles/DMABYTECYCLES && copied < DMALENGTH; copied++ {
+		m.memory[OAMADDR+copied] = m.ReadBus(m.dmaSource + uint16(copied))
+	}
+
+	if copied == DMALENGTH {
+		m.dmaActive = false
+	}
+}
+
+// SerializeState writes or reads the memory, the boot ROM mapping and any OAM DMA transfer.
+// The registers owned by other components are stored by those components. A state saved while the boot ROM was
+// mapped fails to load when there's no boot ROM, as when skipping it.
+func (m *MMU) SerializeState(s *StateSerializer) {
+	s.Raw(m.memory[:])
+	s.Bool(&m.bootROMEnabled)
+	if s.Loading() && m.bootROMEnabled && m.bootROM == nil {
+		s.Fail(errors.New("save state was made while the boot ROM was running, but no boot ROM is loaded"))
+	}
+	s.Bool(&m.dmaActive)
+	s.Uint16(&m.dmaSource)
+	s.Uint64(&m.dmaCycles)
+}
+
 // ReadWord reads a 16-bit word from memory starting at a given address.
 // It returns a word in order lowByte, highByte.
 func (m *MMU) ReadWord(address uint16) uint16 {
-	lowByte := m.memory[address]
-	highByte := m.memory[address+1]
+	lowByte := m.ReadByte(address)
+	highByte := m.ReadByte(address + 1)
 	return U8PairToU16([2]uint8{lowByte, highByte})
 }
 
@@ -50,6 +238,6 @@ func (m *MMU) ReadWord(address uint16) uint16 {
 // The byte is written lowByte, highByte.
 func (m *MMU) WriteWord(address uint16, value uint16) {
 	byteSlice := U16ToU8Pair(value)
-	m.memory[address] = byteSlice[0]
-	m.memory[address+1] = byteSlice[1]
+	m.WriteByte(address, byteSlice[0])
+	m.WriteByte(address+1, byteSlice[1])
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMMURegions(t *testing.T) {
+	mmu := &(MMU{})
+	rom := make([]uint8, 0x8000)
+	rom[0x0000] = 0x31
+	rom[0x4000] = 0x42
+	mmu.LoadCartridge(&ROMOnly{rom: rom})
+
+	mmu.WriteByte(0x4000, 0x00)
+	if mmu.ReadByte(0x4000) != 0x42 {
+		t.Errorf("Write to ROM changed $4000 to %X", mmu.ReadByte(0x4000))
+	}
+
+	mmu.WriteByte(0xC123, 0x55)
+	if mmu.ReadByte(0xE123) != 0x55 {
+		t.Errorf("Echo RAM $E123 = %X, should mirror $C123 = 55", mmu.ReadByte(0xE123))
+	}
+	mmu.WriteByte(0xFDFF, 0x66)
+	if mmu.ReadByte(0xDDFF) != 0x66 {
+		t.Errorf("Write to echo RAM $FDFF did not reach $DDFF, got %X", mmu.ReadByte(0xDDFF))
+	}
+
+	mmu.WriteByte(0xFEA0, 0x77)
+	if mmu.ReadByte(0xFEA0) != 0x00 {
+		t.Errorf("Unusable $FEA0 = %X, should be 0", mmu.ReadByte(0xFEA0))
+	}
+
+	if mmu.ReadByte(0xA000) != 0xFF {
+		t.Errorf("Cartridge without RAM read %X from $A000 instead of FF", mmu.ReadByte(0xA000))
+	}
+
+	mmu.WriteByte(0xFF80, 0x12)
+	mmu.WriteByte(0xFFFF, 0x1F)
+	if mmu.ReadByte(0xFF80) != 0x12 || mmu.ReadByte(0xFFFF) != 0x1F {
+		t.Error("HRAM or IE did not store the written value")
+	}
+}
+
+func TestMMUBootROM(t *testing.T) {
+	mmu := &(MMU{})
+	rom := make([]uint8, 0x8000)
+	rom[0x0000] = 0x31
+	mmu.LoadCartridge(&ROMOnly{rom: rom})
+
+	bootROM := make([]uint8, 0x100)
+	bootROM[0x0000] = 0xAA
+	mmu.LoadBootROM(bootROM)
+
+	if mmu.ReadByte(0x0000) != 0xAA {
+		t.Errorf("Boot ROM not mapped at $0000, read %X", mmu.ReadByte(0x0000))
+	}
+	mmu.WriteByte(0xFF50, 0x01)
+	if mmu.ReadByte(0x0000) != 0x31 {
+		t.Errorf("Boot ROM still mapped after writing $FF50, read %X", mmu.ReadByte(0x0000))
+	}
+}
+
+func TestMMUIO(t *testing.T) {
+	mmu := &(MMU{})
+
+	tables := []struct {
+		address uint16
+		value   uint8
+		read    uint8
+	}{
+		{0xFF04, 0xAB, 0x00}, // DIV resets on write
+		{0xFF07, 0x00, 0xF8}, // TAC upper bits read as 1
+		{0xFF0F, 0x01, 0xE1}, // IF upper bits read as 1
+		{0xFF41, 0x00, 0x80}, // STAT bit 7 reads as 1
+		{0xFF42, 0x12, 0x12}, // SCY
+		{0xFF4C, 0x00, 0xFF}, // Unmapped
+	}
+
+	for _, table := range tables {
+		mmu.WriteByte(table.address, table.value)
+		if read := mmu.ReadByte(table.address); read != table.read {
+			t.Errorf("Wrote %X to $%X, read back %X instead of %X", table.value, table.address, read, table.read)
+		}
+	}
+
+	var owned uint8
+	mmu.RegisterIO(0xFF42, func() uint8 { return 0x34 }, func(value uint8) { owned = value })
+	mmu.WriteByte(0xFF42, 0x56)
+	if owned != 0x56 || mmu.ReadByte(0xFF42) != 0x34 {
+		t.Error("Accesses to an owned register did not reach its handlers")
+	}
+}
+
+func TestMMUDMA(t *testing.T) {
+	mmu := &(MMU{})
+	mmu.Reset()
+	for i := uint16(0); i < DMALENGTH; i++ {
+		mmu.WriteByte(0xC100+i, uint8(i))
+		mmu.WriteByte(OAMADDR+i, 0xFF)
+	}
+	mmu.WriteByte(0xFF80, 0x12)
+	mmu.WriteByte(0xFF46, 0xC1)
+
+	tables := []struct {
+		cycles uint64
+		// Number of bytes copied so far
+		copied uint16
+		active bool
+	}{
+		{0, 0, true},
+		{3, 0, true},
+		{1, 1, true},
+		{316, 80, true},
+		{319, 159, true},
+		{1, 160, false},
+	}
+
+	for _, table := range tables {
+		mmu.Step(table.cycles)
+		if mmu.dmaActive != table.active {
+			t.Errorf("After %d bytes DMA active was %v, want %v", table.copied, mmu.dmaActive, table.active)
+		}
+		for i := uint16(0); i < DMALENGTH; i++ {
+			want := uint8(0xFF)
+			if i < table.copied {
+				want = uint8(i)
+			}
+			if mmu.memory[OAMADDR+i] != want {
+				t.Errorf("After %d bytes OAM $%X was %X, want %X", table.copied, OAMADDR+i, mmu.memory[OAMADDR+i], want)
+				break
+			}
+		}
+		if table.active {
+			if mmu.ReadByte(0xC100) != 0xFF || mmu.ReadByte(0xFF80) != 0x12 {
+				t.Errorf("During DMA read $C100 as %X and $FF80 as %X", mmu.ReadByte(0xC100), mmu.ReadByte(0xFF80))
+			}
+		}
+	}
+
+	if mmu.ReadByte(0xC100) != 0x00 || mmu.ReadByte(0xFF46) != 0xC1 {
+		t.Errorf("After DMA read $C100 as %X and $FF46 as %X", mmu.ReadByte(0xC100), mmu.ReadByte(0xFF46))
+	}
+
+	mmu.WriteByte(0xFF46, 0xC1)
+	mmu.WriteByte(0xC100, 0xAB)
+	mmu.Step(DMALENGTH * DMABYTECYCLES)
+	if mmu.ReadByte(0xC100) != 0x00 {
+		t.Error("Write to WRAM was not blocked during DMA")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build headless
+// +build headless
+
+package main
+
+// runFrontend runs the GameBoy without SDL, for builds on machines without a display or the SDL libraries.
+func runFrontend(gb *GameBoy, options Options) {
+	runHeadless(gb, options)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// MAXSCALE is the largest window scale, which fits the screen in a 1440p display
+const MAXSCALE = 8
+
+// Options are the settings given on the command line.
+type Options struct {
+	ROMPath     string
+	BootROMPath string
+	SkipBoot    bool
+	// Scale is how many times larger than the GameBoy's screen the window is
+	Scale    int
+	Headless bool
+	// Frames is how many frames to run before exiting, or 0 to run until the window is closed
+	Frames  int
+	Palette string
+	// Speed multiplies the frame rate
+	Speed float64
+	// Model is the hardware model whose post-boot state is used when skipping the boot ROM
+	Model    string
+	SaveDir  string
+	LogLevel string
+	Trace    bool
+	// RewindBudget is the memory in MB kept for rewinding, or 0 to disable it
+	RewindBudget int
+}
+
+// ParseOptions parses the command line arguments, not including the program name.
+// The ROM path may come before, after or between the flags. Usage is written to output when the arguments are
+// wrong or -h is given, in which case flag.ErrHelp is returned.
+func ParseOptions(args []string, output io.Writer) (Options, error) {
+	o := Options{}
+	fs := flag.NewFlagSet("goboy", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: goboy [options] rom\n\nOptions:\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&o.BootROMPath, "bootrom", "./data/DMG_ROM.bin", "`path` of the boot ROM")
+	fs.BoolVar(&o.SkipBoot, "skip-boot", false, "start the cartridge straight away without a boot ROM")
+	fs.IntVar(&o.Scale, "scale", 1, fmt.Sprintf("window size as a multiple of the screen, 1-%d", MAXSCALE))
+	fs.BoolVar(&o.Headless, "headless", false, "run without a window, sound or input")
+	fs.IntVar(&o.Frames, "frames", 0, fmt.Sprintf("number of frames to run before exiting, 0 runs until the window is closed or for %d frames when headless", HEADLESSFRAMES))
+	fs.StringVar(&o.Palette, "palette", DEFAULTPALETTE, fmt.Sprintf("screen colors, one of %v", paletteNames()))
+	fs.Float64Var(&o.Speed, "speed", 1, "emulation speed as a multiple of the GameBoy's")
+	fs.StringVar(&o.Model, "model", MODELDMG, fmt.Sprintf("hardware model whose post-boot state --skip-boot starts in, one of %v", modelNames()))
+	fs.StringVar(&o.SaveDir, "savedir", "", "`directory` to keep save files in instead of next to the ROM")
+	fs.StringVar(&o.LogLevel, "log-level", logLevelNames[LOGINFO], fmt.Sprintf("most verbose messages to print, one of %v", logLevelNames))
+	fs.BoolVar(&o.Trace, "trace", false, "print every instruction executed to stdout")
+	fs.IntVar(&o.RewindBudget, "rewind-budget", DEFAULTREWINDBUDGET, "memory in MB kept for rewinding, 0 disables rewinding")
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return o, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(positional) != 1 {
+		fs.Usage()
+		if len(positional) == 0 {
+			return o, errors.New("no ROM given")
+		}
+		return o, fmt.Errorf("expected one ROM, got %d", len(positional))
+	}
+	o.ROMPath = positional[0]
+
+	return o, o.Validate()
+}
+
+// Validate checks that each option is in range.
+func (o Options) Validate() error {
+	if o.Scale < 1 || o.Scale > MAXSCALE {
+		return fmt.Errorf("scale must be between 1 and %d, got %d", MAXSCALE, o.Scale)
+	}
+	if o.Frames < 0 {
+		return fmt.Errorf("frames can't be negative, got %d", o.Frames)
+	}
+	if _, ok := PALETTES[o.Palette]; !ok {
+		return fmt.Errorf("unknown palette %q, expected one of %v", o.Palette, paletteNames())
+	}
+	if o.RewindBudget < 0 {
+		return fmt.Errorf("rewind budget can't be negative, got %d", o.RewindBudget)
+	}
+	if o.Speed <= 0 {
+		return fmt.Errorf("speed must be above 0, got %v", o.Speed)
+	}
+	if _, ok := BOOTSTATES[o.Model]; !ok {
+		return fmt.Errorf("unknown model %q, expected one of %v", o.Model, modelNames())
+	}
+	for _, name := range logLevelNames {
+		if o.LogLevel == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, expected one of %v", o.LogLevel, logLevelNames)
+}
+
+// paletteNames returns the names of the palettes in alphabetical order.
+func paletteNames() []string {
+	names := make([]string, 0, len(PALETTES))
+	for name := range PALETTES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
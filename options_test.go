@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	tables := []struct {
+		args    []string
+		rom     string
+		scale   int
+		palette string
+		err     string
+	}{
+		{[]string{"tetris.gb"}, "tetris.gb", 1, "gray", ""},
+		{[]string{"--scale", "3", "--palette=green", "tetris.gb"}, "tetris.gb", 3, "green", ""},
+		{[]string{"tetris.gb", "--scale", "3", "--headless"}, "tetris.gb", 3, "gray", ""},
+		{[]string{"--scale", "3"}, "", 0, "", "no ROM given"},
+		{[]string{"tetris.gb", "zelda.gb"}, "", 0, "", "expected one ROM"},
+		{[]string{"--scale", "9", "tetris.gb"}, "", 0, "", "scale must be between"},
+		{[]string{"--palette", "pink", "tetris.gb"}, "", 0, "", "unknown palette"},
+		{[]string{"--speed", "0", "tetris.gb"}, "", 0, "", "speed must be above 0"},
+		{[]string{"--model", "gba", "tetris.gb"}, "", 0, "", "unknown model"},
+		{[]string{"--log-level", "loud", "tetris.gb"}, "", 0, "", "unknown log level"},
+		{[]string{"--frames", "-1", "tetris.gb"}, "", 0, "", "frames can't be negative"},
+		{[]string{"--rewind-budget", "-1", "tetris.gb"}, "", 0, "", "rewind budget can't be negative"},
+		{[]string{"--volume", "11", "tetris.gb"}, "", 0, "", "not defined"},
+	}
+
+	for _, table := range tables {
+		o, err := ParseOptions(table.args, ioutil.Discard)
+		if table.err != "" {
+			if err == nil || !strings.Contains(err.Error(), table.err) {
+				t.Errorf("%v: got error %v, want one containing %q", table.args, err, table.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", table.args, err)
+			continue
+		}
+		if o.ROMPath != table.rom || o.Scale != table.scale || o.Palette != table.palette {
+			t.Errorf("%v: parsed ROM %s, scale %d, palette %s", table.args, o.ROMPath, o.Scale, o.Palette)
+		}
+	}
+}
+
+func TestConfigureMissingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	bootROM := filepath.Join(dir, "boot.bin")
+	check(ioutil.WriteFile(bootROM, make([]uint8, BLSIZE), 0644))
+	shortBootROM := filepath.Join(dir, "short.bin")
+	check(ioutil.WriteFile(shortBootROM, make([]uint8, 0x40), 0644))
+	notROM := filepath.Join(dir, "notes.txt")
+	check(ioutil.WriteFile(notROM, []uint8("not a ROM"), 0644))
+	rom := filepath.Join(dir, "test.gb")
+	check(ioutil.WriteFile(rom, newTestROM(2, 0x00, 0x00), 0644))
+
+	tables := []struct {
+		bootROM, rom string
+		skipBoot     bool
+		err          string
+	}{
+		{bootROM, rom, false, ""},
+		{filepath.Join(dir, "missing.bin"), rom, true, ""},
+		{filepath.Join(dir, "missing.bin"), rom, false, "--bootrom"},
+		{shortBootROM, rom, false, "isn't a DMG boot ROM"},
+		{bootROM, filepath.Join(dir, "missing.gb"), false, "doesn't exist"},
+		{bootROM, notROM, false, "isn't a GameBoy ROM"},
+	}
+
+	for _, table := range tables {
+		gb := &(GameBoy{})
+		err := gb.Configure(Options{BootROMPath: table.bootROM, ROMPath: table.rom, SkipBoot: table.skipBoot, Model: MODELDMG})
+		if table.err == "" && err != nil {
+			t.Errorf("Configuring with %s and %s failed: %s", table.bootROM, table.rom, err)
+		} else if table.err != "" && (err == nil || !strings.Contains(err.Error(), table.err)) {
+			t.Errorf("Configuring with %s and %s gave error %v, want one containing %q", table.bootROM, table.rom, err, table.err)
+		}
+	}
+}
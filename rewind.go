@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+)
+
+// REWINDINTERVAL is the number of frames between rewind snapshots
+const REWINDINTERVAL = 4
+
+// DEFAULTREWINDBUDGET is the memory in MB kept for rewinding unless told otherwise, which holds a few minutes
+// of most games
+const DEFAULTREWINDBUDGET = 32
+
+// Rewind keeps a history of save states, taken every few frames, which can be stepped back through.
+// Only the newest snapshot is kept whole. Each older one is stored as the XOR of it and the snapshot after it,
+// compressed. Most of the machine's memory is the same from one snapshot to the next, so the XOR is mostly 0s and
+// compresses to a small fraction of a full snapshot. The oldest snapshots are dropped to stay within the budget.
+type Rewind struct {
+	interval int
+	budget   int
+
+	// latest is the newest snapshot, and deltas the compressed deltas back from it, oldest first
+	latest []uint8
+	deltas [][]uint8
+	// size is the memory used by latest and deltas in bytes
+	size int
+	// frames counts the frames since the last snapshot
+	frames int
+}
+
+// Reset clears the history and sets the number of frames between snapshots and the memory budget in bytes.
+// A budget of 0 disables rewinding.
+func (r *Rewind) Reset(interval int, budget int) {
+	r.interval = interval
+	r.budget = budget
+	r.latest = nil
+	r.deltas = nil
+	r.size = 0
+	r.frames = 0
+}
+
+// Record is called after every frame and snapshots the GameBoy every interval frames.
+func (r *Rewind) Record(gb *GameBoy) {
+	if r.budget == 0 {
+		return
+	}
+	r.frames++
+	if r.frames < r.interval {
+		return
+	}
+	r.frames = 0
+	r.Push(gb.SaveState())
+}
+
+// Push adds a snapshot to the history, storing the previous newest snapshot as a delta from it.
+// Snapshots of a different length, from another ROM, start the history again.
+func (r *Rewind) Push(state []uint8) {
+	if r.latest != nil && len(r.latest) == len(state) {
+		delta := compressDelta(r.latest, state)
+		r.deltas = append(r.deltas, delta)
+		r.size += len(delta) - len(r.latest)
+	} else {
+		r.deltas = nil
+		r.size = 0
+	}
+	r.size += len(state)
+	r.latest = state
+
+	for r.size > r.budget && len(r.deltas) > 0 {
+		r.size -= len(r.deltas[0])
+		r.deltas[0] = nil
+		r.deltas = r.deltas[1:]
+	}
+}
+
+// Pop removes the newest snapshot from the history and returns it, rebuilding the one before it from its delta.
+// It returns false if the history is empty.
+func (r *Rewind) Pop() ([]uint8, bool) {
+	if r.latest == nil {
+		return nil, false
+	}
+	state := r.latest
+	r.size -= len(state)
+	r.latest = nil
+	r.frames = 0
+
+	if n := len(r.deltas); n > 0 {
+		delta := r.deltas[n-1]
+		r.deltas = r.deltas[:n-1]
+		r.size -= len(delta)
+		r.latest = applyDelta(state, delta)
+		r.size += len(r.latest)
+	}
+	return state, true
+}
+
+// Len returns the number of snapshots in the history.
+func (r *Rewind) Len() int {
+	if r.latest == nil {
+		return 0
+	}
+	return len(r.deltas) + 1
+}
+
+// Size returns the memory used by the history in bytes.
+func (r *Rewind) Size() int {
+	return r.size
+}
+
+// compressDelta returns the compressed XOR of two snapshots of the same length.
+func compressDelta(older []uint8, newer []uint8) []uint8 {
+	xor := make([]uint8, len(older))
+	for i := range older {
+		xor[i] = older[i] ^ newer[i]
+	}
+
+	var b bytes.Buffer
+	w, _ := flate.NewWriter(&b, flate.BestSpeed)
+	w.Write(xor)
+	w.Close()
+	return b.Bytes()
+}
+
+// applyDelta rebuilds the older snapshot from a newer one and the compressed delta between them.
+func applyDelta(newer []uint8, delta []uint8) []uint8 {
+	xor, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(delta)))
+	// The deltas never leave memory, so they can only fail to decompress through a bug
+	check(err)
+
+	older := make([]uint8, len(newer))
+	for i := range newer {
+		older[i] = newer[i] ^ xor[i]
+	}
+	return older
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRewindHistory(t *testing.T) {
+	r := &(Rewind{})
+	r.Reset(1, 1<<20)
+	for i := 0; i < 10; i++ {
+		r.Push(newRewindState(i))
+	}
+
+	if r.Len() != 10 {
+		t.Errorf("History holds %d snapshots, want 10", r.Len())
+	}
+	// The deltas between snapshots should compress to far less than a whole snapshot
+	if r.Size() > 2*MEMORYSIZE {
+		t.Errorf("10 snapshots take 0x%X bytes", r.Size())
+	}
+
+	for i := 9; i >= 0; i-- {
+		state, ok := r.Pop()
+		if !ok || string(state) != string(newRewindState(i)) {
+			t.Errorf("Popped snapshot %d wrongly, ok %t", i, ok)
+		}
+	}
+	if _, ok := r.Pop(); ok || r.Len() != 0 || r.Size() != 0 {
+		t.Errorf("Empty history popped a snapshot, or holds %d snapshots in 0x%X bytes", r.Len(), r.Size())
+	}
+}
+
+func TestRewindBudget(t *testing.T) {
+	tables := []struct {
+		budget int
+		kept   int
+	}{
+		{0, 1},
+		{MEMORYSIZE + 1, 1},
+		{1 << 20, 50},
+	}
+
+	for _, table := range tables {
+		r := &(Rewind{})
+		r.Reset(1, table.budget)
+		for i := 0; i < 50; i++ {
+			r.Push(newRewindState(i))
+		}
+		if r.Len() < table.kept || r.Size() > table.budget && r.Len() > 1 {
+			t.Errorf("Budget 0x%X kept %d snapshots in 0x%X bytes, want at least %d", table.budget, r.Len(), r.Size(), table.kept)
+		}
+		if state, _ := r.Pop(); string(state) != string(newRewindState(49)) {
+			t.Errorf("Budget 0x%X lost the newest snapshot", table.budget)
+		}
+	}
+}
+
+func TestRewindRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	gb := newTestGameBoy(dir, STATEPROGRAM, MODELDMG)
+	h := &(Headless{})
+	h.Start(gb)
+
+	r := &(Rewind{})
+	r.Reset(REWINDINTERVAL, DEFAULTREWINDBUDGET<<20)
+	counts := []uint8{}
+	for i := 0; i < 5*REWINDINTERVAL; i++ {
+		h.Step()
+		r.Record(gb)
+		if (i+1)%REWINDINTERVAL == 0 {
+			counts = append(counts, gb.mmu.ReadByte(0xC000))
+		}
+	}
+	if r.Len() != 5 {
+		t.Errorf("Recording %d frames took %d snapshots, want 5", 5*REWINDINTERVAL, r.Len())
+	}
+
+	for i := len(counts) - 1; i >= 0; i-- {
+		state, _ := r.Pop()
+		check(gb.LoadState(state))
+		if count := gb.mmu.ReadByte(0xC000); count != counts[i] {
+			t.Errorf("Rewound to counter %X, want %X", count, counts[i])
+		}
+	}
+
+	// A disabled history records nothing
+	r.Reset(REWINDINTERVAL, 0)
+	h.RunFrames(REWINDINTERVAL)
+	r.Record(gb)
+	if r.Len() != 0 {
+		t.Errorf("Disabled history holds %d snapshots", r.Len())
+	}
+}
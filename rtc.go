@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RTC register constants are the values written to the MBC3 RAM bank register to map that RTC register
+// into 0xA000-0xBFFF.
+const (
+	RTCSECONDS = 0x08
+	RTCMINUTES = 0x09
+	RTCHOURS   = 0x0A
+	RTCDAYLOW  = 0x0B
+	RTCDAYHIGH = 0x0C
+)
+
+// Clock is a source of the current time for a cartridge real-time clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock which reads the host wall clock.
+type SystemClock struct{}
+
+// Now returns the current host time.
+func (s SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// RTC is the real-time clock of an MBC3 cartridge.
+// Rather than ticking every second, the live registers are brought up to date from the clock whenever
+// they are accessed.
+type RTC struct {
+	clock Clock
+	// last is the time the live registers were last brought up to date
+	last time.Time
+
+	seconds  uint8
+	minutes  uint8
+	hours    uint8
+	days     uint16
+	halted   bool
+	dayCarry bool
+
+	// latched holds the S, M, H, DL and DH registers as of the last latch, which is what the CPU reads.
+	latched [5]uint8
+}
+
+// Update advances the live registers by the whole seconds passed since they were last updated, unless halted.
+func (r *RTC) Update() {
+	now := r.clock.Now()
+	if r.last.IsZero() || r.halted || now.Before(r.last) {
+		r.last = now
+		return
+	}
+
+	elapsed := now.Sub(r.last) / time.Second
+	r.last = r.last.Add(elapsed * time.Second)
+	r.Advance(int64(elapsed))
+}
+
+// Advance adds a number of seconds to the live registers, setting the day carry bit if the 9-bit day
+// counter overflows.
+func (r *RTC) Advance(seconds int64) {
+	total := int64(r.seconds) + seconds
+	r.seconds = uint8(total % 60)
+	total = int64(r.minutes) + total/60
+	r.minutes = uint8(total % 60)
+	total = int64(r.hours) + total/60
+	r.hours = uint8(total % 24)
+	total = int64(r.days) + total/24
+	if total > 0x1FF {
+		r.dayCarry = true
+	}
+	r.days = uint16(total % 0x200)
+}
+
+// Registers returns the live S, M, H, DL and DH register values.
+// DH holds bit 8 of the day counter in bit 0, the halt flag in bit 6 and the day carry in bit 7.
+func (r *RTC) Registers() [5]uint8 {
+	dayHigh := uint8(r.days>>8) & 1
+	if r.halted {
+		dayHigh |= BitVal(6)
+	}
+	if r.dayCarry {
+		dayHigh |= BitVal(7)
+	}
+	return [5]uint8{r.seconds, r.minutes, r.hours, uint8(r.days), dayHigh}
+}
+
+// Latch copies the live registers into the latched registers read by the CPU.
+func (r *RTC) Latch() {
+	r.Update()
+	r.latched = r.Registers()
+}
+
+// Read returns the latched value of an RTC register.
+func (r *RTC) Read(register uint8) uint8 {
+	return r.latched[register-RTCSECONDS]
+}
+
+// Write sets the live value of an RTC register.
+func (r *RTC) Write(register uint8, value uint8) {
+	r.Update()
+
+	switch register {
+	case RTCSECONDS:
+		r.seconds = value & 0x3F
+		// Writing the seconds resets the sub-second counter
+		r.last = r.clock.Now()
+	case RTCMINUTES:
+		r.minutes = value & 0x3F
+	case RTCHOURS:
+		r.hours = value & 0x1F
+	case RTCDAYLOW:
+		r.days = r.days&0x100 | uint16(value)
+	case RTCDAYHIGH:
+		r.days = r.days&0xFF | uint16(value&1)<<8
+		r.halted = CheckBit(&value, 6)
+		r.dayCarry = CheckBit(&value, 7)
+	}
+}
+
+// SerializeState writes or reads the live and latched registers and the time they were last updated.
+func (r *RTC) SerializeState(s *StateSerializer) {
+	s.Uint8(&r.seconds)
+	s.Uint8(&r.minutes)
+	s.Uint8(&r.hours)
+	s.Uint16(&r.days)
+	s.Bool(&r.halted)
+	s.Bool(&r.dayCarry)
+	s.Raw(r.latched[:])
+
+	// The zero time means the registers have never been updated
+	last := int64(0)
+	if !r.last.IsZero() {
+		last = r.last.UnixNano()
+	}
+	s.Int64(&last)
+	if s.Loading() {
+		r.last = time.Time{}
+		if last != 0 {
+			r.last = time.Unix(0, last)
+		}
+	}
+}
+
+// RTCFOOTERSIZE is the size of the RTC data appended to MBC3 saves. Some emulators write a 32-bit
+// timestamp instead of a 64-bit one, making the footer 4 bytes shorter.
+const RTCFOOTERSIZE = 48
+
+// Footer returns the RTC state in the format appended to .sav files by other emulators: the live and then
+// latched S, M, H, DL and DH registers as 32-bit little-endian values, then the 64-bit little-endian UNIX
+// time it was saved at.
+func (r *RTC) Footer() []uint8 {
+	r.Update()
+
+	footer := make([]uint8, 0, RTCFOOTERSIZE)
+	live := r.Registers()
+	for _, registers := range [][5]uint8{live, r.latched} {
+		for _, v := range registers {
+			footer = append(footer, v, 0, 0, 0)
+		}
+	}
+
+	timestamp := uint64(r.last.Unix())
+	for i := uint(0); i < 8; i++ {
+		footer = append(footer, uint8(timestamp>>(8*i)))
+	}
+	return footer
+}
+
+// LoadFooter restores the RTC state from a .sav file footer and advances it by the time passed since it was
+// saved.
+func (r *RTC) LoadFooter(footer []uint8) error {
+	if len(footer) != RTCFOOTERSIZE && len(footer) != RTCFOOTERSIZE-4 {
+		return fmt.Errorf("RTC footer is %d bytes long, should be %d or %d", len(footer), RTCFOOTERSIZE, RTCFOOTERSIZE-4)
+	}
+
+	for i := 0; i < 5; i++ {
+		r.latched[i] = footer[20+4*i]
+	}
+	r.seconds = footer[0] & 0x3F
+	r.minutes = footer[4] & 0x3F
+	r.hours = footer[8] & 0x1F
+	dayHigh := footer[16]
+	r.days = uint16(dayHigh&1)<<8 | uint16(footer[12])
+	r.halted = CheckBit(&dayHigh, 6)
+	r.dayCarry = CheckBit(&dayHigh, 7)
+
+	timestamp := uint64(0)
+	for i := len(footer) - 1; i >= 40; i-- {
+		timestamp = timestamp<<8 | uint64(footer[i])
+	}
+	r.last = time.Unix(int64(timestamp), 0)
+	r.Update()
+	return nil
+}
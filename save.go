@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SAVEDEBOUNCEFRAMES is the number of frames without cartridge RAM writes after which the save file is written,
+// so a game writing its save over several frames is flushed once it has finished.
+const SAVEDEBOUNCEFRAMES = 60
+
+// BatteryBacked is implemented by cartridges whose RAM, and RTC, can be saved to and restored from a .sav file.
+type BatteryBacked interface {
+	// SaveData returns the contents of the .sav file.
+	SaveData() []uint8
+	// LoadSaveData restores the RAM, and RTC, from the contents of a .sav file.
+	LoadSaveData(data []uint8) error
+	// RAMWrites returns the number of writes made to the RAM since it was created.
+	RAMWrites() uint64
+}
+
+// HasBattery returns true if a cartridge type from the header keeps its RAM powered by a battery.
+func HasBattery(cartridgeType uint8) bool {
+	switch cartridgeType {
+	case 0x03, 0x06, 0x09, 0x0F, 0x10, 0x13, 0x1B, 0x1E:
+		return true
+	default:
+		return false
+	}
+}
+
+// SavePath returns the path of the .sav file for a ROM, which is the ROM path with its extension replaced.
+// If saveDir isn't empty the file goes in that directory instead of next to the ROM.
+func SavePath(romPath string, saveDir string) string {
+	path := strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sav"
+	if saveDir != "" {
+		path = filepath.Join(saveDir, filepath.Base(path))
+	}
+	return path
+}
+
+// SaveRAM is the RAM of a cartridge, saved in the raw format used by other emulators.
+type SaveRAM struct {
+	ram    []uint8
+	writes uint64
+}
+
+// writeRAMBank writes a byte to an offset into a RAM bank and counts the write.
+func (s *SaveRAM) writeRAMBank(bank int, offset uint16, value uint8) {
+	writeBank(s.ram, RAMBANKSIZE, bank, offset, value)
+	s.writes++
+}
+
+// SaveData returns a copy of the RAM.
+func (s *SaveRAM) SaveData() []uint8 {
+	data := make([]uint8, len(s.ram))
+	copy(data, s.ram)
+	return data
+}
+
+// LoadSaveData copies save data into the RAM. Any data past the size of the RAM is ignored.
+func (s *SaveRAM) LoadSaveData(data []uint8) error {
+	if len(data) < len(s.ram) {
+		return fmt.Errorf("save data is 0x%X bytes long, cartridge RAM is 0x%X bytes", len(data), len(s.ram))
+	}
+	copy(s.ram, data)
+	return nil
+}
+
+// SerializeState writes or reads the RAM. Loading counts as a write, so the save file picks up the restored RAM.
+func (s *SaveRAM) SerializeState(state *StateSerializer) {
+	state.Raw(s.ram)
+	if state.Loading() {
+		s.writes++
+	}
+}
+
+// RAMWrites returns the number of writes made to the RAM.
+func (s *SaveRAM) RAMWrites() uint64 {
+	return s.writes
+}
+
+// SaveFile keeps a .sav file in sync with the RAM of a battery backed cartridge.
+type SaveFile struct {
+	path      string
+	cartridge BatteryBacked
+
+	// writes is the RAM write count as of the last tick
+	writes     uint64
+	dirty      bool
+	idleFrames int
+}
+
+// Load restores the cartridge RAM from the save file, if it exists.
+func (s *SaveFile) Load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := s.cartridge.LoadSaveData(data); err != nil {
+		return fmt.Errorf("loading %s: %v", s.path, err)
+	}
+	s.writes = s.cartridge.RAMWrites()
+	return nil
+}
+
+// Tick is called once per frame and writes the save file once the RAM has been changed and then left
+// alone for SAVEDEBOUNCEFRAMES frames.
+func (s *SaveFile) Tick() error {
+	if writes := s.cartridge.RAMWrites(); writes != s.writes {
+		s.writes = writes
+		s.dirty = true
+		s.idleFrames = 0
+		return nil
+	}
+
+	if !s.dirty {
+		return nil
+	}
+	s.idleFrames++
+	if s.idleFrames < SAVEDEBOUNCEFRAMES {
+		return nil
+	}
+	return s.Flush()
+}
+
+// Flush writes the save file if the RAM has changed since it was last written.
+// The data is written to a temporary file first so a crash can't leave a half written save.
+func (s *SaveFile) Flush() error {
+	if s.cartridge.RAMWrites() != s.writes {
+		s.writes = s.cartridge.RAMWrites()
+		s.dirty = true
+	}
+	if !s.dirty {
+		return nil
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, s.cartridge.SaveData(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	s.idleFrames = 0
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSavePath(t *testing.T) {
+	tables := []struct {
+		romPath, saveDir string
+		path             string
+	}{
+		{"./data/Pokemon Gold.gbc", "", "./data/Pokemon Gold.sav"},
+		{"./data/Pokemon Gold.gbc", "saves", "saves/Pokemon Gold.sav"},
+	}
+
+	for _, table := range tables {
+		if path := SavePath(table.romPath, table.saveDir); path != table.path {
+			t.Errorf("Save path of %s in %q is %s, want %s", table.romPath, table.saveDir, path, table.path)
+		}
+	}
+}
+
+func TestSaveFileDebounce(t *testing.T) {
+	cartridge, err := NewCartridge(newTestROM(4, 0x03, 0x02))
+	check(err)
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	save := &SaveFile{path: filepath.Join(dir, "test.sav"), cartridge: cartridge.(BatteryBacked)}
+	check(save.Load())
+
+	cartridge.WriteROM(0x0000, 0x0A)
+	for i := 0; i < 10; i++ {
+		cartridge.WriteRAM(0xA000+uint16(i), uint8(i))
+		check(save.Tick())
+	}
+	for i := 0; i < SAVEDEBOUNCEFRAMES-1; i++ {
+		check(save.Tick())
+	}
+	if _, err := os.Stat(save.path); !os.IsNotExist(err) {
+		t.Fatal("Save file written before the RAM was left alone for long enough")
+	}
+
+	check(save.Tick())
+	data, err := ioutil.ReadFile(save.path)
+	check(err)
+	if len(data) != RAMBANKSIZE || data[9] != 9 {
+		t.Errorf("Save file is 0x%X bytes with $A009 = %X, should be 0x2000 bytes with 9", len(data), data[9])
+	}
+
+	// Reload the save into a new cartridge
+	cartridge, err = NewCartridge(newTestROM(4, 0x03, 0x02))
+	check(err)
+	save = &SaveFile{path: save.path, cartridge: cartridge.(BatteryBacked)}
+	check(save.Load())
+	cartridge.WriteROM(0x0000, 0x0A)
+	if cartridge.ReadRAM(0xA009) != 9 {
+		t.Errorf("Loaded save has $A009 = %X instead of 9", cartridge.ReadRAM(0xA009))
+	}
+}
+
+func TestRTCSaveFooter(t *testing.T) {
+	mbc3, clock := newTestMBC3()
+	mbc3.WriteROM(0x4000, 0x00)
+	mbc3.WriteRAM(0xA000, 0x42)
+	mbc3.WriteROM(0x4000, RTCHOURS)
+	mbc3.WriteRAM(0xA000, 5)
+
+	data := mbc3.SaveData()
+	if len(data) != 0x8000+RTCFOOTERSIZE {
+		t.Fatalf("MBC3 save is 0x%X bytes long instead of 0x%X", len(data), 0x8000+RTCFOOTERSIZE)
+	}
+
+	// Loading two hours later advances the clock
+	loaded, _ := newTestMBC3()
+	loaded.rtc.clock = &fakeClock{now: clock.now.Add(2 * time.Hour)}
+	check(loaded.LoadSaveData(data))
+	if h := readRTC(loaded, RTCHOURS); h != 7 {
+		t.Errorf("Loaded RTC hours = %d instead of 7", h)
+	}
+
+	// A 44 byte footer with a 32-bit timestamp is also accepted
+	loaded, _ = newTestMBC3()
+	loaded.rtc.clock = clock
+	check(loaded.LoadSaveData(data[:len(data)-4]))
+	if h := readRTC(loaded, RTCHOURS); h != 5 {
+		t.Errorf("Loaded RTC hours = %d instead of 5", h)
+	}
+	loaded.WriteROM(0x4000, 0x00)
+	if !bytes.Equal(loaded.SaveData()[:0x8000], data[:0x8000]) {
+		t.Error("Loaded RAM differs from the saved RAM")
+	}
+}
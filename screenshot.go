@@ -0,0 +1,25 @@
+package main
+
+import (
+	"image"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScreenshotPath returns the path of a screenshot of a ROM taken at a given time, next to the ROM file.
+// Screenshots of ./data/Tetris.gb are named like ./data/Tetris-20060102-150405.png.
+func ScreenshotPath(romPath string, t time.Time) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + t.Format("-20060102-150405") + ".png"
+}
+
+// FrameImage converts a frame of shades into an image using the colors of a palette.
+func FrameImage(frame [SCREENWIDTH * SCREENHEIGHT]uint8, palette Palette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, SCREENWIDTH, SCREENHEIGHT))
+	for y := 0; y < SCREENHEIGHT; y++ {
+		for x := 0; x < SCREENWIDTH; x++ {
+			img.SetRGBA(x, y, palette[frame[y*SCREENWIDTH+x]&0x03])
+		}
+	}
+	return img
+}
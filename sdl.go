@@ -1,36 +1,75 @@
+//go:build !headless
+// +build !headless
+
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/veandco/go-sdl2/gfx"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-// Define the pixel width and height of the GameBoy display
-const (
-	SCREENWIDTH  = 160
-	SCREENHEIGHT = 144
-)
+// runFrontend runs the GameBoy in an SDL window.
+// Build with the headless tag to leave out SDL.
+func runFrontend(gb *GameBoy, options Options) {
+	s := &(SDL{scale: options.Scale, palette: PALETTES[options.Palette], frames: options.Frames})
+	s.rewind.Reset(REWINDINTERVAL, options.RewindBudget<<20)
+	s.Start(gb)
+}
 
 // SDL is a struct which acts as the display for the GameBoy
-type SDL struct{}
+type SDL struct {
+	bindings Bindings
+	// Bindings looked up by SDL's codes for each input. Axes are bound separately in their negative and positive
+	// directions.
+	keys    map[sdl.Keycode]string
+	buttons map[sdl.GameControllerButton]string
+	axes    map[sdl.GameControllerAxis][2]string
+	// axisDirections holds the direction each axis was last pushed in, -1, 0 or 1
+	axisDirections map[sdl.GameControllerAxis]int
+
+	controllers []*sdl.GameController
+	paused      bool
+	// slot is the save state slot used by the save and load state hotkeys
+	slot int
+	// rewind records the history, which is played back instead of running frames while rewinding is set by holding
+	// the rewind hotkey
+	rewind    Rewind
+	rewinding bool
+
+	// scale is the size of each GameBoy pixel in the window
+	scale   int
+	palette Palette
+	// frames is the number of frames to run before closing, or 0 to run until the window is closed
+	frames int
 
-// Start acts as a wrapper for restarting and loading the GameBoy.
-// TODO: This is not how it should work long term, but for now we're only ever loading one file so eh...
+	audio        sdl.AudioDeviceID
+	audioSamples []int16
+	audioBytes   []byte
+}
+
+// MAXQUEUEDAUDIO is the most audio in bytes kept queued for the audio device, about a tenth of a second at 48 kHz.
+// Audio produced while more than this is queued, like when fast forwarding, is dropped rather than adding latency.
+const MAXQUEUEDAUDIO = 4 * 4800
+
+// Start opens a window and runs a GameBoy with a ROM loaded until the window is closed.
 func (s *SDL) Start(gb *GameBoy) {
-	gb.Reset()
-	gb.LoadROMFromFile("./data/Tetris.gb")
+	bindings, err := LoadBindings(BINDINGSPATH)
+	if err != nil {
+		Logf(LOGWARN, "%s, using the default bindings", err)
+	}
+	s.SetBindings(bindings)
 
 	// Start the gameboy
 	gbStepper := gb.Start()
 
 	var winTitle = "goboy"
-	var bgPixelWidth = 256
 	var window *sdl.Window
 	var renderer *sdl.Renderer
-	var err error
 
 	if err = sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		if _, err = fmt.Fprintf(os.Stderr, "Failed to initialize SDL: %s\n", err); err != nil {
@@ -38,8 +77,16 @@ func (s *SDL) Start(gb *GameBoy) {
 		}
 	}
 	defer sdl.Quit()
+	defer s.CloseControllers()
 
-	if window, err = sdl.CreateWindow(winTitle, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, SCREENWIDTH, SCREENHEIGHT, sdl.WINDOW_SHOWN); err != nil {
+	if err = s.OpenAudio(gb); err != nil {
+		Logf(LOGWARN, "Failed to open audio device, continuing without sound: %s", err)
+	} else {
+		defer sdl.CloseAudioDevice(s.audio)
+	}
+
+	scale := int32(s.scale)
+	if window, err = sdl.CreateWindow(winTitle, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, SCREENWIDTH*scale, SCREENHEIGHT*scale, sdl.WINDOW_SHOWN); err != nil {
 		if _, err = fmt.Fprintf(os.Stderr, "Failed to create window: %s\n", err); err != nil {
 			panic(err)
 		}
@@ -51,64 +98,33 @@ func (s *SDL) Start(gb *GameBoy) {
 			panic(err)
 		}
 	}
+	renderer.SetScale(float32(scale), float32(scale))
 	renderer.SetDrawColor(0, 0, 0, 255)
 	renderer.Clear()
 	defer renderer.Destroy()
 
 	renderer.Present()
-	pxArray := [0x10000]uint8{}
-	for {
+	for frames := 0; s.HandleEvents(gb) && (s.frames == 0 || frames < s.frames); {
+		if s.paused {
+			sdl.Delay(16)
+			continue
+		}
+
 		check(renderer.Clear())
-		pxArray = gb.lcd.GetBGPixelArray()
-		SCX := gb.mmu.ReadByte(0xFF43)
-		SCY := gb.mmu.ReadByte(0xFF42)
-
-		gbStepper()
-
-		// Dump screen and crash when finished booting
-		// if gb.cpu.PC.word > 0x100 {
-		// 	f, err := os.Create("./BGScreenDump")
-		// 	check(err)
-		// 	defer f.Close()
-
-		// 	var vramPixels bytes.Buffer
-
-		// 	for y := 0; y < 256; y++ {
-		// 		for x := 0; x < 256; x++ {
-		// 			vramPixels.WriteString(strconv.Itoa(int(pxArray[x+SCREENWIDTH*y])))
-		// 		}
-		// 		vramPixels.WriteString("\n")
-		// 	}
-		// 	f.WriteString(vramPixels.String())
-
-		// 	f3, err3 := os.Create("./Tiles")
-		// 	check(err3)
-		// 	defer f.Close()
-
-		// 	var tilesBuf bytes.Buffer
-
-		// 	for tid := 0; tid < 19; tid++ {
-		// 		tile := gb.lcd.LoadTileFromAddress(0x8000 + uint16(tid*16))
-
-		// 		tilesBuf.WriteString(strconv.Itoa(tid))
-		// 		for i := 0; i < 64; i++ {
-		// 			s := strconv.Itoa(int(tile[i]))
-
-		// 			if i%8 == 0 {
-		// 				tilesBuf.WriteString("\n")
-		// 			}
-		// 			tilesBuf.WriteString(s)
-		// 		}
-		// 		tilesBuf.WriteString("\n\n")
-		// 	}
-		// 	f3.WriteString(tilesBuf.String())
-
-		// 	panic("finished booting")
-		// }
-
-		for x := uint8(0); x < SCREENWIDTH; x++ {
-			for y := uint8(0); y < SCREENHEIGHT; y++ {
-				gfx.PixelColor(renderer, int32(SCX+x), int32(SCY+y), s.ConvertColor(pxArray[int(SCX+x)+bgPixelWidth*int(SCX+y)]))
+
+		if s.rewinding {
+			s.Rewind(gb)
+		} else {
+			gbStepper()
+			frames++
+			s.rewind.Record(gb)
+			s.QueueAudio(gb)
+		}
+		frame := gb.Frame()
+
+		for y := 0; y < SCREENHEIGHT; y++ {
+			for x := 0; x < SCREENWIDTH; x++ {
+				gfx.PixelColor(renderer, int32(x), int32(y), s.ConvertColor(frame[y*SCREENWIDTH+x]))
 			}
 		}
 
@@ -116,16 +132,205 @@ func (s *SDL) Start(gb *GameBoy) {
 	}
 }
 
-// ConvertColor is a helper class which converts a pixel value 0-3 into the corresponding display color for the screen.
-func (s *SDL) ConvertColor(p uint8) sdl.Color {
-	switch p {
-	case 0:
-		return sdl.Color{R: 255, G: 255, B: 255, A: 255}
-	case 1:
-		return sdl.Color{R: 170, G: 170, B: 170, A: 255}
-	case 2:
-		return sdl.Color{R: 80, G: 80, B: 80, A: 255}
+// SetBindings looks up the SDL codes of the inputs in a set of bindings.
+// Inputs SDL doesn't recognize are reported and ignored.
+func (s *SDL) SetBindings(bindings Bindings) {
+	s.bindings = bindings
+	s.keys = map[sdl.Keycode]string{}
+	s.buttons = map[sdl.GameControllerButton]string{}
+	s.axes = map[sdl.GameControllerAxis][2]string{}
+	s.axisDirections = map[sdl.GameControllerAxis]int{}
+
+	for name, action := range bindings.Keys {
+		if key := sdl.GetKeyFromName(name); key != sdl.K_UNKNOWN {
+			s.keys[key] = action
+		} else {
+			Logf(LOGWARN, "Ignoring binding for unknown key %q", name)
+		}
+	}
+	for name, action := range bindings.ControllerButtons {
+		if button := sdl.GameControllerGetButtonFromString(name); button != sdl.CONTROLLER_BUTTON_INVALID {
+			s.buttons[button] = action
+		} else {
+			Logf(LOGWARN, "Ignoring binding for unknown controller button %q", name)
+		}
+	}
+	for name, action := range bindings.ControllerAxes {
+		axis := sdl.GameControllerGetAxisFromString(name[:len(name)-1])
+		if axis == sdl.CONTROLLER_AXIS_INVALID {
+			Logf(LOGWARN, "Ignoring binding for unknown controller axis %q", name)
+			continue
+		}
+		directions := s.axes[axis]
+		if name[len(name)-1] == '+' {
+			directions[1] = action
+		} else {
+			directions[0] = action
+		}
+		s.axes[axis] = directions
+	}
+}
+
+// OpenAudio opens the default audio device for 16-bit stereo output and sets the GameBoy's sample rate to match.
+func (s *SDL) OpenAudio(gb *GameBoy) error {
+	desired := &sdl.AudioSpec{Freq: AUDIOSAMPLERATE, Format: sdl.AUDIO_S16LSB, Channels: 2, Samples: 1024}
+	obtained := &sdl.AudioSpec{}
+	device, err := sdl.OpenAudioDevice("", false, desired, obtained, sdl.AUDIO_ALLOW_FREQUENCY_CHANGE)
+	if err != nil {
+		return err
+	}
+
+	s.audio = device
+	s.audioSamples = make([]int16, 2*AUDIOBUFFERFRAMES)
+	s.audioBytes = make([]byte, 4*AUDIOBUFFERFRAMES)
+	gb.SetSampleRate(int(obtained.Freq))
+	sdl.PauseAudioDevice(device, false)
+	return nil
+}
+
+// QueueAudio passes the audio produced by the GameBoy since the last call on to the audio device.
+func (s *SDL) QueueAudio(gb *GameBoy) {
+	if s.audio == 0 {
+		return
+	}
+
+	n := gb.AudioSamples(s.audioSamples)
+	if sdl.GetQueuedAudioSize(s.audio) > MAXQUEUEDAUDIO {
+		return
+	}
+	for i, sample := range s.audioSamples[:n] {
+		binary.LittleEndian.PutUint16(s.audioBytes[2*i:], uint16(sample))
+	}
+	if err := sdl.QueueAudio(s.audio, s.audioBytes[:2*n]); err != nil {
+		Logf(LOGERROR, "Failed to queue audio: %s", err)
+	}
+}
+
+// CloseControllers closes any game controllers opened while handling events.
+func (s *SDL) CloseControllers() {
+	for _, controller := range s.controllers {
+		controller.Close()
+	}
+	s.controllers = nil
+}
+
+// HandleEvents passes keyboard and game controller input on to the GameBoy's joypad and handles hotkeys.
+// It returns false once the window is closed or the quit hotkey is pressed.
+func (s *SDL) HandleEvents(gb *GameBoy) bool {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch e := event.(type) {
+		case *sdl.QuitEvent:
+			return false
+		case *sdl.KeyboardEvent:
+			if e.Repeat != 0 {
+				continue
+			}
+			if !s.Action(gb, s.keys[e.Keysym.Sym], e.Type == sdl.KEYDOWN) {
+				return false
+			}
+		case *sdl.ControllerButtonEvent:
+			if !s.Action(gb, s.buttons[sdl.GameControllerButton(e.Button)], e.Type == sdl.CONTROLLERBUTTONDOWN) {
+				return false
+			}
+		case *sdl.ControllerAxisEvent:
+			axis := sdl.GameControllerAxis(e.Axis)
+			direction := s.bindings.AxisDirection(e.Value)
+			previous := s.axisDirections[axis]
+			if direction == previous {
+				continue
+			}
+			s.axisDirections[axis] = direction
+			// Index 0 holds the action for the negative direction and 1 the positive
+			if previous != 0 && !s.Action(gb, s.axes[axis][(previous+1)/2], false) {
+				return false
+			}
+			if direction != 0 && !s.Action(gb, s.axes[axis][(direction+1)/2], true) {
+				return false
+			}
+		case *sdl.ControllerDeviceEvent:
+			if e.Type == sdl.CONTROLLERDEVICEADDED {
+				if controller := sdl.GameControllerOpen(int(e.Which)); controller != nil {
+					s.controllers = append(s.controllers, controller)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Action presses or releases the joypad button or hotkey bound to an input.
+// Unbound inputs have an empty action and are ignored. It returns false if the quit hotkey was pressed.
+func (s *SDL) Action(gb *GameBoy, action string, pressed bool) bool {
+	button, hotkey, ok := ParseAction(action)
+	switch {
+	case !ok:
+		return true
+	case hotkey == "":
+		if pressed {
+			gb.Press(button)
+		} else {
+			gb.Release(button)
+		}
+		return true
+	case hotkey == HOTKEYFASTFORWARD:
+		// Fast forward lasts as long as the input is held
+		gb.SetFastForward(pressed)
+		return true
+	case hotkey == HOTKEYREWIND:
+		// Rewinding also lasts as long as the input is held
+		s.rewinding = pressed
+		return true
+	case !pressed:
+		// Other hotkeys act when pressed
+		return true
+	}
+
+	switch hotkey {
+	case HOTKEYQUIT:
+		return false
+	case HOTKEYPAUSE:
+		s.paused = !s.paused
+	case HOTKEYSAVESTATE:
+		if path, err := gb.SaveStateToSlot(s.slot); err != nil {
+			Logf(LOGERROR, "Failed to save state: %s", err)
+		} else {
+			Logf(LOGINFO, "Saved state to slot %d, %s", s.slot, path)
+		}
+	case HOTKEYLOADSTATE:
+		if _, err := gb.LoadStateFromSlot(s.slot); err != nil {
+			Logf(LOGERROR, "Failed to load state: %s", err)
+		} else {
+			Logf(LOGINFO, "Loaded state from slot %d", s.slot)
+		}
+	case HOTKEYSCREENSHOT:
+		if path, err := gb.SaveScreenshot(s.palette); err != nil {
+			Logf(LOGERROR, "Failed to save screenshot: %s", err)
+		} else {
+			Logf(LOGINFO, "Saved screenshot to %s", path)
+		}
 	default:
-		return sdl.Color{R: 0, G: 0, B: 0, A: 255}
+		if slot, ok := SlotHotkey(hotkey); ok {
+			s.slot = slot
+			Logf(LOGINFO, "Selected save state slot %d", slot)
+		}
+	}
+	return true
+}
+
+// Rewind steps the GameBoy back to the newest snapshot in the rewind history.
+// Snapshots are REWINDINTERVAL frames apart, so each is shown for that many frames to play back at normal speed.
+// Once the history runs out the GameBoy stays on the oldest snapshot.
+func (s *SDL) Rewind(gb *GameBoy) {
+	if state, ok := s.rewind.Pop(); ok {
+		if err := gb.LoadState(state); err != nil {
+			Logf(LOGERROR, "Failed to rewind: %s", err)
+		}
 	}
+	sdl.Delay(uint32(REWINDINTERVAL * FRAMEDURATION / time.Millisecond))
+}
+
+// ConvertColor is a helper class which converts a shade 0-3 into the corresponding display color for the screen.
+func (s *SDL) ConvertColor(p uint8) sdl.Color {
+	c := s.palette[p&0x03]
+	return sdl.Color{R: c.R, G: c.G, B: c.B, A: c.A}
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// STATEMAGIC starts every save state, to tell them apart from other files
+const STATEMAGIC = "GBST"
+
+// STATEVERSION is the version of the save state format. It goes up whenever the state of a component changes,
+// since older states can't be loaded into the new layout.
+const STATEVERSION = 1
+
+// STATEHEADERSIZE is the size of the save state header: the magic, the 16-bit format version and the CRC-32 of
+// the ROM the state was saved from
+const STATEHEADERSIZE = 10
+
+// STATESLOTS is the number of numbered save state slots
+const STATESLOTS = 10
+
+// errStateTruncated is returned when a save state ends before all of the machine state has been read
+var errStateTruncated = errors.New("save state is truncated")
+
+// StateSerializer writes the machine state to a save state, or reads it back.
+// Each component describes its state once by passing pointers to its fields, which are either written out in
+// order or overwritten with the values read, so saving and loading can't get out of step.
+type StateSerializer struct {
+	buffer  *bytes.Buffer
+	loading bool
+	err     error
+}
+
+// NewStateWriter returns a serializer which writes state after a header for a ROM with a CRC-32.
+func NewStateWriter(romChecksum uint32) *StateSerializer {
+	s := &(StateSerializer{buffer: &bytes.Buffer{}})
+	s.buffer.WriteString(STATEMAGIC)
+	version := uint16(STATEVERSION)
+	s.Uint16(&version)
+	s.Uint32(&romChecksum)
+	return s
+}
+
+// NewStateReader returns a serializer which reads the state from a save state, after checking that its header
+// matches the format version and the CRC-32 of the ROM.
+func NewStateReader(data []uint8, romChecksum uint32) (*StateSerializer, error) {
+	if len(data) < STATEHEADERSIZE || string(data[:len(STATEMAGIC)]) != STATEMAGIC {
+		return nil, errors.New("not a save state")
+	}
+
+	s := &(StateSerializer{buffer: bytes.NewBuffer(data[len(STATEMAGIC):]), loading: true})
+	var version uint16
+	var checksum uint32
+	s.Uint16(&version)
+	s.Uint32(&checksum)
+	if version != STATEVERSION {
+		return nil, fmt.Errorf("save state is format version %d, only version %d can be loaded", version, STATEVERSION)
+	}
+	if checksum != romChecksum {
+		return nil, fmt.Errorf("save state is for a different ROM, with CRC-32 %08X instead of %08X", checksum, romChecksum)
+	}
+	return s, nil
+}
+
+// Loading returns true if the serializer reads state rather than writing it.
+func (s *StateSerializer) Loading() bool {
+	return s.loading
+}
+
+// Bytes returns the save state written so far.
+func (s *StateSerializer) Bytes() []uint8 {
+	return s.buffer.Bytes()
+}
+
+// Err returns the first error hit while reading, and an error if any data is left over after the state.
+func (s *StateSerializer) Err() error {
+	if s.err == nil && s.loading && s.buffer.Len() != 0 {
+		return fmt.Errorf("save state has 0x%X bytes left over", s.buffer.Len())
+	}
+	return s.err
+}
+
+// Fail records an error which makes the state fail to load, for a component which reads back a state it can't
+// run from. Only the first error is kept.
+func (s *StateSerializer) Fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Raw writes or reads a block of data whose length is fixed by the machine, like a memory region.
+func (s *StateSerializer) Raw(data []uint8) {
+	if !s.loading {
+		s.buffer.Write(data)
+		return
+	}
+	if s.err != nil {
+		return
+	}
+	if n, _ := s.buffer.Read(data); n != len(data) {
+		s.err = errStateTruncated
+	}
+}
+
+// Uint8 writes or reads an 8-bit value.
+func (s *StateSerializer) Uint8(v *uint8) {
+	data := []uint8{*v}
+	s.Raw(data)
+	*v = data[0]
+}
+
+// Uint16 writes or reads a 16-bit value.
+func (s *StateSerializer) Uint16(v *uint16) {
+	data := make([]uint8, 2)
+	binary.LittleEndian.PutUint16(data, *v)
+	s.Raw(data)
+	*v = binary.LittleEndian.Uint16(data)
+}
+
+// Uint32 writes or reads a 32-bit value.
+func (s *StateSerializer) Uint32(v *uint32) {
+	data := make([]uint8, 4)
+	binary.LittleEndian.PutUint32(data, *v)
+	s.Raw(data)
+	*v = binary.LittleEndian.Uint32(data)
+}
+
+// Uint64 writes or reads a 64-bit value.
+func (s *StateSerializer) Uint64(v *uint64) {
+	data := make([]uint8, 8)
+	binary.LittleEndian.PutUint64(data, *v)
+	s.Raw(data)
+	*v = binary.LittleEndian.Uint64(data)
+}
+
+// Int64 writes or reads a signed 64-bit value.
+func (s *StateSerializer) Int64(v *int64) {
+	u := uint64(*v)
+	s.Uint64(&u)
+	*v = int64(u)
+}
+
+// Int writes or reads an int as a signed 64-bit value.
+func (s *StateSerializer) Int(v *int) {
+	i := int64(*v)
+	s.Int64(&i)
+	*v = int(i)
+}
+
+// Bool writes or reads a bool as a byte.
+func (s *StateSerializer) Bool(v *bool) {
+	b := uint8(0)
+	if *v {
+		b = 1
+	}
+	s.Uint8(&b)
+	*v = b != 0
+}
+
+// StatePath returns the path of a numbered save state slot for a ROM, which is the ROM path with its extension
+// replaced by .ss and the slot number. If saveDir isn't empty the file goes in that directory instead.
+func StatePath(romPath string, saveDir string, slot int) string {
+	path := fmt.Sprintf("%s.ss%d", strings.TrimSuffix(romPath, filepath.Ext(romPath)), slot)
+	if saveDir != "" {
+		path = filepath.Join(saveDir, filepath.Base(path))
+	}
+	return path
+}
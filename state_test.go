@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// STATEPROGRAM counts up at $C000 and plays a sound, so the CPU, memory, APU and timer all change from frame to frame:
+// LD A,$80; LDH ($26),A; LDH ($12),A; LD HL,$C000; EI; loop: INC (HL); LDH A,($04); LDH ($14),A; JR loop
+var STATEPROGRAM = []uint8{0x3E, 0x80, 0xE0, 0x26, 0xE0, 0x12, 0x21, 0x00, 0xC0, 0xFB, 0x34, 0xF0, 0x04, 0xE0, 0x14, 0x18, 0xF9}
+
+func TestStateSerializer(t *testing.T) {
+	a, b, c, d := uint8(0x12), uint16(0x3456), -7, true
+	w := NewStateWriter(0xDEADBEEF)
+	w.Uint8(&a)
+	w.Uint16(&b)
+	w.Int(&c)
+	w.Bool(&d)
+	data := w.Bytes()
+
+	a, b, c, d = 0, 0, 0, false
+	r, err := NewStateReader(data, 0xDEADBEEF)
+	check(err)
+	r.Uint8(&a)
+	r.Uint16(&b)
+	r.Int(&c)
+	r.Bool(&d)
+	if err := r.Err(); err != nil || a != 0x12 || b != 0x3456 || c != -7 || !d {
+		t.Errorf("Read back %X, %X, %d, %t with error %v", a, b, c, d, err)
+	}
+
+	tables := []struct {
+		name     string
+		data     []uint8
+		checksum uint32
+		err      string
+	}{
+		{"Wrong magic", append([]uint8("GBSV"), data[4:]...), 0xDEADBEEF, "not a save state"},
+		{"Short header", data[:6], 0xDEADBEEF, "not a save state"},
+		{"New version", append(append([]uint8(STATEMAGIC), 0x02, 0x00), data[6:]...), 0xDEADBEEF, "format version 2"},
+		{"Different ROM", data, 0x12345678, "different ROM"},
+	}
+
+	for _, table := range tables {
+		if _, err := NewStateReader(table.data, table.checksum); err == nil || !strings.Contains(err.Error(), table.err) {
+			t.Errorf("%s: got error %v, want one containing %q", table.name, err, table.err)
+		}
+	}
+
+	r, err = NewStateReader(data[:len(data)-2], 0xDEADBEEF)
+	check(err)
+	r.Uint8(&a)
+	r.Uint16(&b)
+	r.Int(&c)
+	if r.Err() != errStateTruncated {
+		t.Errorf("Reading past the end gave error %v", r.Err())
+	}
+	r, err = NewStateReader(data, 0xDEADBEEF)
+	check(err)
+	r.Uint8(&a)
+	if err := r.Err(); err == nil || !strings.Contains(err.Error(), "left over") {
+		t.Errorf("Leaving data unread gave error %v", err)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	gb := newTestGameBoy(dir, STATEPROGRAM, MODELDMG)
+	h := &(Headless{})
+	h.Start(gb)
+	h.RunFrames(10)
+
+	state := gb.SaveState()
+	h.RunFrames(10)
+	after := gb.SaveState()
+	frame := gb.Frame()
+
+	check(gb.LoadState(state))
+	if string(gb.SaveState()) != string(state) {
+		t.Error("Loading a state and saving it again gave a different state")
+	}
+	h.RunFrames(10)
+	if string(gb.SaveState()) != string(after) || gb.Frame() != frame {
+		t.Error("Running on from a loaded state didn't repeat the same frames")
+	}
+	if gb.cpu.AF.word != uint16(*gb.cpu.AF.hi)<<8|uint16(*gb.cpu.AF.lo) {
+		t.Errorf("AF is %04X but its halves are %02X and %02X", gb.cpu.AF.word, *gb.cpu.AF.hi, *gb.cpu.AF.lo)
+	}
+
+	// A state which fails to load leaves the machine alone
+	if err := gb.LoadState(state[:len(state)-100]); err != errStateTruncated {
+		t.Errorf("Loading a truncated state gave error %v", err)
+	}
+	if string(gb.SaveState()) != string(after) {
+		t.Error("A failed load changed the machine")
+	}
+}
+
+func TestBootStateWithoutBootROM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	gb := newTestGameBoy(dir, STATEPROGRAM, MODELDMG)
+
+	// Save a state while the boot ROM is still mapped, from the same ROM without skipping the boot
+	booting := newTestGameBoy(dir, STATEPROGRAM, "")
+	h := &(Headless{})
+	h.Start(booting)
+	h.Step()
+	state := booting.SaveState()
+
+	h.Start(gb)
+	h.RunFrames(2)
+	before := gb.SaveState()
+	if err := gb.LoadState(state); err == nil || !strings.Contains(err.Error(), "boot ROM") {
+		t.Errorf("Loading a state from during boot without a boot ROM gave error %v", err)
+	}
+	if string(gb.SaveState()) != string(before) {
+		t.Error("A failed load changed the machine")
+	}
+	h.RunFrames(2)
+}
+
+func TestStateSlots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goboy")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	gb := newTestGameBoy(dir, STATEPROGRAM, MODELDMG)
+	gb.saveDir = filepath.Join(dir, "saves")
+	check(os.Mkdir(gb.saveDir, 0755))
+	h := &(Headless{})
+	h.Start(gb)
+	h.RunFrames(5)
+
+	path, err := gb.SaveStateToSlot(3)
+	check(err)
+	if path != filepath.Join(dir, "saves", "test.ss3") {
+		t.Errorf("Slot 3 was saved to %s", path)
+	}
+	count := gb.mmu.ReadByte(0xC000)
+	h.RunFrames(5)
+
+	if _, err := gb.LoadStateFromSlot(3); err != nil {
+		t.Errorf("Loading slot 3 failed: %s", err)
+	}
+	if gb.mmu.ReadByte(0xC000) != count {
+		t.Errorf("Counter is %X after loading slot 3, want %X", gb.mmu.ReadByte(0xC000), count)
+	}
+	if _, err := gb.LoadStateFromSlot(4); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("Loading empty slot 4 gave error %v", err)
+	}
+}
+
+func TestCartridgeState(t *testing.T) {
+	tables := []struct {
+		cartridgeType uint8
+	}{
+		{0x03}, // MBC1
+		{0x10}, // MBC3 with RTC
+		{0x1B}, // MBC5
+	}
+
+	for _, table := range tables {
+		rom := newTestROM(8, table.cartridgeType, 0x03)
+		cartridge, err := NewCartridge(rom)
+		check(err)
+		cartridge.WriteROM(0x0000, 0x0A)
+		cartridge.WriteROM(0x2000, 0x05)
+		cartridge.WriteROM(0x4000, 0x01)
+		cartridge.WriteROM(0x6000, 0x01)
+		cartridge.WriteRAM(0xA123, 0x77)
+
+		w := NewStateWriter(0)
+		cartridge.SerializeState(w)
+
+		restored, err := NewCartridge(rom)
+		check(err)
+		r, err := NewStateReader(w.Bytes(), 0)
+		check(err)
+		restored.SerializeState(r)
+		if err := r.Err(); err != nil {
+			t.Errorf("Cartridge type %X: %s", table.cartridgeType, err)
+		}
+		if bank := restored.ReadROM(0x4000); bank != cartridge.ReadROM(0x4000) || bank == 1 {
+			t.Errorf("Cartridge type %X: restored ROM bank %d, want %d", table.cartridgeType, bank, cartridge.ReadROM(0x4000))
+		}
+		if value := restored.ReadRAM(0xA123); value != 0x77 {
+			t.Errorf("Cartridge type %X: restored RAM read %X, want 77", table.cartridgeType, value)
+		}
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	tables := []struct {
+		romPath, saveDir string
+		slot             int
+		path             string
+	}{
+		{"./data/Tetris.gb", "", 0, "./data/Tetris.ss0"},
+		{"./data/Tetris.gb", "saves", 7, "saves/Tetris.ss7"},
+	}
+
+	for _, table := range tables {
+		if path := StatePath(table.romPath, table.saveDir, table.slot); path != table.path {
+			t.Errorf("Slot %d of %s in %q is %s, want %s", table.slot, table.romPath, table.saveDir, path, table.path)
+		}
+	}
+}
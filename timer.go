@@ -0,0 +1,126 @@
+package main
+
+// timerBits maps the clock select bits of TAC to the bit of the internal divider whose falling edge increments TIMA.
+// In order these are 4096 Hz, 262144 Hz, 65536 Hz and 16384 Hz.
+var timerBits = [4]uint8{9, 3, 5, 7}
+
+// Timer is the divider and the programmable timer.
+// Both are driven by a 16-bit internal counter which increases every cycle. DIV is its upper byte, and TIMA is
+// incremented whenever the counter bit selected by TAC falls from 1 to 0 while the timer is enabled.
+type Timer struct {
+	mmu *MMU
+
+	divider uint16
+	tima    uint8
+	tma     uint8
+	tac     uint8
+
+	// After TIMA overflows it reads 0 for a cycle before being reloaded from TMA and requesting the interrupt.
+	// overflowing is set during that cycle and reloading during the cycle in which TMA is loaded.
+	overflowing bool
+	reloading   bool
+
+	// frameSequencer is called on each falling edge of bit 4 of DIV, 512 times a second
+	frameSequencer func()
+}
+
+// Reset clears the timer and registers its I/O registers with the MMU.
+func (t *Timer) Reset(m *MMU) {
+	t.mmu = m
+	t.divider = 0
+	t.tima = 0
+	t.tma = 0
+	t.tac = 0
+	t.overflowing = false
+	t.reloading = false
+
+	m.RegisterIO(0xFF04, func() uint8 { return uint8(t.divider >> 8) }, func(uint8) { t.SetDivider(0) })
+	m.RegisterIO(0xFF05, func() uint8 { return t.tima }, t.WriteTIMA)
+	m.RegisterIO(0xFF06, func() uint8 { return t.tma }, t.WriteTMA)
+	m.RegisterIO(0xFF07, func() uint8 { return t.tac }, t.WriteTAC)
+}
+
+// OnFrameSequencer sets a function to be called on each falling edge of bit 4 of DIV, which clocks the APU's
+// frame sequencer. Resetting DIV while the bit is set causes an extra clock.
+func (t *Timer) OnFrameSequencer(clock func()) {
+	t.frameSequencer = clock
+}
+
+// Step advances the timer by a number of cycles, one machine cycle of 4 cycles at a time.
+func (t *Timer) Step(cycles uint64) {
+	for i := uint64(0); i < cycles; i += 4 {
+		t.reloading = false
+		if t.overflowing {
+			t.overflowing = false
+			t.reloading = true
+			t.tima = t.tma
+			t.mmu.RequestInterrupt(TIMER)
+		}
+		t.SetDivider(t.divider + 4)
+	}
+}
+
+// Signal returns the input to the TIMA falling edge detector, the selected divider bit ANDed with the enable bit.
+func (t *Timer) Signal() bool {
+	return CheckBit(&t.tac, 2) && t.divider&(1<<timerBits[t.tac&0x03]) != 0
+}
+
+// SerializeState writes or reads the divider and the timer registers.
+func (t *Timer) SerializeState(s *StateSerializer) {
+	s.Uint16(&t.divider)
+	s.Uint8(&t.tima)
+	s.Uint8(&t.tma)
+	s.Uint8(&t.tac)
+	s.Bool(&t.overflowing)
+	s.Bool(&t.reloading)
+}
+
+// SetDivider changes the internal counter, incrementing TIMA if the selected bit falls.
+// Because of this, resetting DIV while the bit is set increments TIMA.
+func (t *Timer) SetDivider(value uint16) {
+	before := t.Signal()
+	if t.frameSequencer != nil && t.divider&0x1000 != 0 && value&0x1000 == 0 {
+		t.frameSequencer()
+	}
+	t.divider = value
+	if before && !t.Signal() {
+		t.IncTIMA()
+	}
+}
+
+// IncTIMA increments TIMA, starting the delayed reload if it overflows.
+func (t *Timer) IncTIMA() {
+	t.tima++
+	if t.tima == 0 {
+		t.overflowing = true
+	}
+}
+
+// WriteTIMA sets TIMA.
+// A write in the cycle after an overflow cancels the reload and interrupt, and a write in the cycle TMA is loaded
+// is ignored.
+func (t *Timer) WriteTIMA(value uint8) {
+	if t.reloading {
+		return
+	}
+	t.overflowing = false
+	t.tima = value
+}
+
+// WriteTMA sets TMA. A write in the cycle TMA is being loaded into TIMA also reaches TIMA.
+func (t *Timer) WriteTMA(value uint8) {
+	t.tma = value
+	if t.reloading {
+		t.tima = value
+	}
+}
+
+// WriteTAC sets TAC. On the DMG, disabling the timer or changing its frequency while the selected bit is set
+// increments TIMA, since the edge detector sees the signal fall.
+func (t *Timer) WriteTAC(value uint8) {
+	before := t.Signal()
+	t.tac = value & 0x07
+	if before && !t.Signal() {
+		t.IncTIMA()
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTimerDIV(t *testing.T) {
+	timer, mmu := newTestTimer()
+
+	timer.Step(252)
+	if div := mmu.ReadByte(0xFF04); div != 0 {
+		t.Errorf("DIV was %d after 252 cycles, want 0", div)
+	}
+	timer.Step(4)
+	if div := mmu.ReadByte(0xFF04); div != 1 {
+		t.Errorf("DIV was %d after 256 cycles, want 1", div)
+	}
+	timer.Step(256 * 255)
+	if div := mmu.ReadByte(0xFF04); div != 0 {
+		t.Errorf("DIV was %d after 65536 cycles, want 0", div)
+	}
+
+	timer.Step(256 * 10)
+	mmu.WriteByte(0xFF04, 0x55)
+	if div := mmu.ReadByte(0xFF04); div != 0 || timer.divider != 0 {
+		t.Errorf("Writing DIV left DIV %d and counter %d", div, timer.divider)
+	}
+}
+
+func TestTimerFrequencies(t *testing.T) {
+	tables := []struct {
+		tac    uint8
+		cycles uint64
+		tima   uint8
+	}{
+		{0x04, 1024, 1},
+		{0x04, 1020, 0},
+		{0x05, 16, 1},
+		{0x05, 160, 10},
+		{0x06, 64, 1},
+		{0x06, 640, 10},
+		{0x07, 256, 1},
+		{0x07, 2560, 10},
+		{0x03, 2560, 0}, // Disabled
+	}
+
+	for _, table := range tables {
+		timer, mmu := newTestTimer()
+		mmu.WriteByte(0xFF05, 0)
+		mmu.WriteByte(0xFF07, table.tac)
+		timer.Step(table.cycles)
+		if tima := mmu.ReadByte(0xFF05); tima != table.tima {
+			t.Errorf("TAC %X: TIMA was %d after %d cycles, want %d", table.tac, tima, table.cycles, table.tima)
+		}
+	}
+}
+
+func TestTimerOverflow(t *testing.T) {
+	tables := []struct {
+		name string
+		// write is called after TIMA overflows, following delay cycles
+		delay uint64
+		write func(mmu *MMU)
+		tima  uint8
+		irq   bool
+	}{
+		{"Reload", 4, func(mmu *MMU) {}, 0x10, true},
+		// TIMA reads 0 for a cycle before it is reloaded
+		{"Overflow cycle", 0, func(mmu *MMU) {}, 0x00, true},
+		// Writing TIMA in the cycle after overflowing cancels the reload and the interrupt
+		{"TIMA write cancels reload", 0, func(mmu *MMU) { mmu.WriteByte(0xFF05, 0x42) }, 0x42, false},
+		// Writing TIMA in the cycle it is reloaded is ignored
+		{"TIMA write during reload", 4, func(mmu *MMU) { mmu.WriteByte(0xFF05, 0x42) }, 0x10, true},
+		// Writing TMA in the cycle it is loaded also sets TIMA
+		{"TMA write during reload", 4, func(mmu *MMU) { mmu.WriteByte(0xFF06, 0x42) }, 0x42, true},
+		{"TIMA write after reload", 8, func(mmu *MMU) { mmu.WriteByte(0xFF05, 0x42) }, 0x42, true},
+	}
+
+	for _, table := range tables {
+		timer, mmu := newTestTimer()
+		mmu.WriteByte(0xFF05, 0xFF)
+		mmu.WriteByte(0xFF06, 0x10)
+		mmu.WriteByte(0xFF07, 0x05)
+		// TIMA overflows on the 16th cycle
+		timer.Step(16 + table.delay)
+		table.write(mmu)
+
+		if tima := mmu.ReadByte(0xFF05); tima != table.tima {
+			t.Errorf("%s: TIMA was %X, want %X", table.name, tima, table.tima)
+		}
+		timer.Step(4)
+		if irq := mmu.ReadByte(IFADDR)&BitVal(TIMER) != 0; irq != table.irq {
+			t.Errorf("%s: timer interrupt requested %v, want %v", table.name, irq, table.irq)
+		}
+	}
+}
+
+func TestTimerGlitches(t *testing.T) {
+	tables := []struct {
+		name   string
+		tac    uint8
+		cycles uint64
+		write  func(mmu *MMU)
+		tima   uint8
+	}{
+		// Resetting DIV while the selected bit is set is a falling edge
+		{"DIV reset with bit set", 0x05, 8, func(mmu *MMU) { mmu.WriteByte(0xFF04, 0) }, 1},
+		{"DIV reset with bit clear", 0x05, 4, func(mmu *MMU) { mmu.WriteByte(0xFF04, 0) }, 0},
+		// Disabling the timer while the selected bit is set is a falling edge
+		{"Disable with bit set", 0x05, 8, func(mmu *MMU) { mmu.WriteByte(0xFF07, 0x01) }, 1},
+		{"Disable with bit clear", 0x05, 4, func(mmu *MMU) { mmu.WriteByte(0xFF07, 0x01) }, 0},
+		// Switching to a frequency whose bit is clear while the old bit is set is a falling edge
+		{"Frequency change", 0x05, 8, func(mmu *MMU) { mmu.WriteByte(0xFF07, 0x06) }, 1},
+		{"Frequency change to set bit", 0x06, 40, func(mmu *MMU) { mmu.WriteByte(0xFF07, 0x05) }, 0},
+	}
+
+	for _, table := range tables {
+		timer, mmu := newTestTimer()
+		mmu.WriteByte(0xFF05, 0)
+		mmu.WriteByte(0xFF07, table.tac)
+		timer.Step(table.cycles)
+		table.write(mmu)
+		if tima := mmu.ReadByte(0xFF05); tima != table.tima {
+			t.Errorf("%s: TIMA was %d, want %d", table.name, tima, table.tima)
+		}
+	}
+}